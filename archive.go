@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/pkg/errors"
+)
+
+const (
+	formatZip   = "zip"
+	formatTarGz = "tar.gz"
+
+	// checksumsFile lists the SHA-256 of every archived regular file in the
+	// format understood by sha256sum -c.
+	checksumsFile = "SHA256SUMS"
+)
+
+// archiveEntry describes a single file found in the working directory.
+type archiveEntry struct {
+	// name is the slash separated path relative to the archive root.
+	name string
+	// data is the content of regular files.
+	data io.Reader
+	info fs.FileInfo
+	// linkTarget is set for symbolic links only.
+	linkTarget string
+	modified   time.Time
+}
+
+// archiveOptions configure how the working directory is archived.
+type archiveOptions struct {
+	format string
+	// level is the compression level, where 0 stores files uncompressed and
+	// flate.DefaultCompression picks the default.
+	level int
+	// workers compress zip entries in parallel when above 1.
+	workers int
+	// recipient, when set, encrypts the archive.
+	recipient age.Recipient
+	// modTimes overrides the modification time of files per repository
+	// directory.
+	modTimes map[string]time.Time
+	// ignore, when set, excludes files matching it relative to their
+	// repository root.
+	ignore gitignore.Matcher
+}
+
+// archiveWriter writes entries in a specific archive format.
+type archiveWriter interface {
+	writeEntry(entry archiveEntry) error
+	Close() error
+}
+
+// newArchiveWriter creates a writer for the format and compression of opts.
+// Zip entries are compressed by opts.workers in parallel, while tar.gz is a
+// single gzip stream compressed serially.
+func newArchiveWriter(w io.Writer, opts archiveOptions) (archiveWriter, error) {
+	level := opts.level
+	if opts.format == formatTarGz {
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &tarGzArchiveWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	}
+
+	if level != flate.NoCompression && opts.workers > 1 {
+		return newParallelZipArchiveWriter(w, level, opts.workers), nil
+	}
+
+	zw := zip.NewWriter(w)
+	method := zip.Store
+	if level != flate.NoCompression {
+		method = zip.Deflate
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return &zipArchiveWriter{w: zw, method: method}, nil
+}
+
+// writeArchive packs dirFilename into a new archive file at archivePath.
+func writeArchive(dirFilename, archivePath string, opts archiveOptions) error {
+	archiveFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return errors.Wrap(err, "could not open archive file")
+	}
+	defer archiveFile.Close()
+
+	err = writeArchiveTo(dirFilename, archiveFile, opts)
+	if err != nil {
+		return err
+	}
+	return archiveFile.Close()
+}
+
+// writeArchiveTo packs dirFilename into an archive written to out.
+func writeArchiveTo(dirFilename string, out io.Writer, opts archiveOptions) error {
+	enc, err := encryptTo(out, opts.recipient)
+	if err != nil {
+		return errors.Wrap(err, "could not start encryption")
+	}
+	w, err := newArchiveWriter(enc, opts)
+	if err != nil {
+		return errors.Wrap(err, "could not create archive writer")
+	}
+	err = fillArchive(dirFilename, w, opts)
+	if err != nil {
+		return errors.Wrap(err, "could not fill archive")
+	}
+	err = w.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not finalize archive")
+	}
+	err = enc.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not finalize encryption")
+	}
+	return nil
+}
+
+// fillArchive adds every file under dirFilename not ignored by opts to w,
+// followed by the checksums of all of them. Entries of files belonging to a
+// repository directory listed in opts.modTimes get that time as their
+// modification time instead of the checkout time found on disk.
+func fillArchive(dirFilename string, w archiveWriter, opts archiveOptions) error {
+	sums := &strings.Builder{}
+	err := filepath.WalkDir(dirFilename, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dirFilename, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if ignored(opts.ignore, rel, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		file, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		e := archiveEntry{
+			name:     rel,
+			info:     file,
+			modified: file.ModTime(),
+		}
+		repoDir, _, _ := strings.Cut(rel, "/")
+		if modTime, ok := opts.modTimes[repoDir]; ok {
+			e.modified = modTime
+		}
+
+		if file.Mode()&os.ModeSymlink == os.ModeSymlink {
+			e.linkTarget, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		if !file.Mode().IsRegular() {
+			return w.writeEntry(e)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// hash the content while it is archived, so files are read only once
+		h := sha256.New()
+		e.data = io.TeeReader(f, h)
+		err = w.writeEntry(e)
+		if err != nil {
+			return err
+		}
+		sums.WriteString(hex.EncodeToString(h.Sum(nil)) + "  " + rel + "\n")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeChecksums(dirFilename, w, sums.String())
+}
+
+// writeChecksums stores sums next to the archived files and adds them to w as
+// the last entry.
+func writeChecksums(dirFilename string, w archiveWriter, sums string) error {
+	path := filepath.Join(dirFilename, checksumsFile)
+	err := os.WriteFile(path, []byte(sums), fileMode)
+	if err != nil {
+		return errors.Wrap(err, "could not write checksums")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return w.writeEntry(archiveEntry{
+		name:     checksumsFile,
+		data:     strings.NewReader(sums),
+		info:     info,
+		modified: info.ModTime(),
+	})
+}
+
+type zipArchiveWriter struct {
+	w      *zip.Writer
+	method uint16
+}
+
+func (z *zipArchiveWriter) writeEntry(entry archiveEntry) error {
+	if entry.linkTarget != "" {
+		header := &zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Store,
+			Modified: entry.modified,
+		}
+		header.SetMode(os.ModeSymlink)
+
+		writer, err := z.w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = writer.Write([]byte(entry.linkTarget))
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(entry.info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	header.Modified = entry.modified
+	header.Method = z.method
+
+	writer, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if !entry.info.Mode().IsRegular() {
+		return nil
+	}
+	_, err = io.Copy(writer, entry.data)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.w.Close()
+}
+
+type tarGzArchiveWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzArchiveWriter) writeEntry(entry archiveEntry) error {
+	header, err := tar.FileInfoHeader(entry.info, entry.linkTarget)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	header.ModTime = entry.modified
+
+	err = t.tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if !entry.info.Mode().IsRegular() {
+		return nil
+	}
+	_, err = io.Copy(t.tw, entry.data)
+	return err
+}
+
+func (t *tarGzArchiveWriter) Close() error {
+	err := t.tw.Close()
+	if err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	appInstallationTokenURL = "%s/app/installations/%s/access_tokens"
+	// appJWTLifetime stays below the 10 minutes maximum accepted by GitHub.
+	appJWTLifetime = 9 * time.Minute
+	// tokenRefreshMargin renews installation tokens before they expire so
+	// that a clone started right before the expiry still authenticates.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// tokenSource provides the token used to authenticate against GitHub, both
+// for API requests and for cloning.
+type tokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+type staticToken string
+
+func (t staticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// fileToken is a token read from GITHUB_TOKEN_FILE, told apart from
+// staticToken to name the right option when GitHub rejects it.
+type fileToken string
+
+func (t fileToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// credentialName names the options tokens come from, for errors asking to
+// check them.
+func credentialName(tokens tokenSource) string {
+	switch tokens.(type) {
+	case *appTokenSource:
+		return "the GitHub App installation set with GITHUB_APP_INSTALLATION_ID"
+	case fileToken:
+		return "GITHUB_TOKEN_FILE"
+	default:
+		return "GITHUB_TOKEN"
+	}
+}
+
+// appTokenSource mints GitHub App installation access tokens, refreshing
+// them once they get close to their expiry. Tokens are requested through api
+// like any other API request, api authenticating with the minted tokens in
+// turn.
+type appTokenSource struct {
+	api            *apiClient
+	appID          string
+	installationID string
+	key            *rsa.PrivateKey
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newAppTokenSource(api *apiClient, appID, installationID string, privateKeyPEM []byte) (*appTokenSource, error) {
+	key, err := parseRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &appTokenSource{
+		api:            api,
+		appID:          appID,
+		installationID: installationID,
+		key:            key,
+	}, nil
+}
+
+func (s *appTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expiresAt) > tokenRefreshMargin {
+		return s.token, nil
+	}
+
+	jwt, err := s.jwt(time.Now())
+	if err != nil {
+		return "", errors.Wrap(err, "could not sign app JWT")
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(appInstallationTokenURL, s.api.baseURL, s.installationID), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create new http request")
+	}
+	r.Header.Set("Accept", "application/vnd.github+json")
+	r.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := s.api.doAPIRequest(ctx, r)
+	if err != nil {
+		return "", errors.Wrap(err, "could not request installation token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(resp, "for installation token")
+	}
+
+	installationToken := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&installationToken)
+	if err != nil {
+		return "", errors.Wrap(err, "could not decode installation token")
+	}
+
+	s.token = installationToken.Token
+	s.expiresAt = installationToken.ExpiresAt
+	slog.Debug("installation token minted", "expires_at", s.expiresAt)
+	return s.token, nil
+}
+
+// jwt creates the RS256 signed token authenticating as the app itself.
+func (s *appTokenSource) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		// backdated to allow for clock drift between us and GitHub
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parseRSAPrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse private key")
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// tokenAuth authenticates git HTTP requests with a token fetched from tokens
+// on every request, so long clones pick up refreshed tokens.
+type tokenAuth struct {
+	tokens tokenSource
+}
+
+func (a *tokenAuth) SetAuth(r *http.Request) {
+	token, err := a.tokens.Token(r.Context())
+	if err != nil {
+		slog.Error("could not get token for git authentication", "err", err)
+		return
+	}
+	r.SetBasicAuth("username", token)
+}
+
+func (a *tokenAuth) Name() string {
+	return "http-token-auth"
+}
+
+func (a *tokenAuth) String() string {
+	return fmt.Sprintf("%s - %s", a.Name(), "username:*******")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppTokenSourceThroughAPIClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	requests := atomic.Int64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodPost || r.URL.Path != "/app/installations/2/access_tokens" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "installation", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	t.Cleanup(srv.Close)
+
+	api := newAPIClient(srv.URL, srv.Client(), nil, 1)
+	tokens, err := newAppTokenSource(api, "1", "2", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.tokens = tokens
+
+	// with the only request slot taken the token has to wait for it
+	api.sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = tokens.Token(ctx)
+	if err == nil || requests.Load() != 0 {
+		t.Fatalf("token requested with no free slot, err %v", err)
+	}
+	<-api.sem
+
+	for range 2 {
+		token, err := tokens.Token(context.Background())
+		if err != nil || token != "installation" {
+			t.Fatalf("token %q, %v", token, err)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d token requests, want 1 as the token is cached", got)
+	}
+}
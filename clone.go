@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/pkg/errors"
+)
+
+// allBranchesRefSpec makes every remote branch a local one, so that the
+// archived repository keeps the history of all of them.
+const allBranchesRefSpec = "+refs/heads/*:refs/heads/*"
+
+type cloneConfig struct {
+	workers     int
+	depth       int
+	mirror      bool
+	allBranches bool
+	fetchLFS    bool
+	submodules  bool
+	// api and the fetch flags configure API metadata stored next to the
+	// clones.
+	api           *apiClient
+	metadataPool  *fetchWorkerPool[int]
+	fetchReleases bool
+	fetchIssues   bool
+	fetchPulls    bool
+	fetchWiki     bool
+	// tokens authenticate clones over HTTPS, the API ones unless CLONE_TOKEN
+	// is set.
+	tokens tokenSource
+	// sshAuth, when set, is used to clone over SSH instead of HTTPS with
+	// a token from tokens.
+	sshAuth    *ssh.PublicKeys
+	sshKeyPath string
+	// previous enables incremental runs, reusing unchanged clones from it.
+	previous *previousArchive
+	// cloner clones the repositories, newGitCloner unless replaced.
+	cloner cloner
+	// ref is cloned instead of the default branch, unless refs holds another
+	// one for the repository.
+	ref  string
+	refs map[string]string
+	// postCloneHook is a command run on every cloned repository.
+	postCloneHook []string
+	// timeout bounds every clone, 0 leaves them bound by the program timeout
+	// only.
+	timeout time.Duration
+	// caCertFile, when set, is the certificate bundle the git binary
+	// verifies servers with, like the HTTP client does.
+	caCertFile string
+	// insecureSkipTLSVerify turns off verifying servers for the git binary.
+	insecureSkipTLSVerify bool
+	// singleBranch fetches the history of the default branch only.
+	singleBranch bool
+	// fetchCollaborators stores who has access to each repository.
+	fetchCollaborators    bool
+	fetchWebhooks         bool
+	fetchBranchProtection bool
+	// stripGit removes the git directories of clones before archiving.
+	stripGit bool
+	// logs, when set, gets the log records of every repository while it is
+	// archived.
+	logs *repoLogs
+}
+
+// refFor is the full name of the ref to clone for the repository name, empty
+// for the default branch. Short names are taken as branches.
+func (cfg cloneConfig) refFor(name string) plumbing.ReferenceName {
+	ref := cfg.ref
+	if r, ok := cfg.refs[name]; ok {
+		ref = r
+	}
+	if ref == "" || strings.HasPrefix(ref, "refs/") {
+		return plumbing.ReferenceName(ref)
+	}
+	return plumbing.NewBranchReferenceName(ref)
+}
+
+// optionsFor are the clone options of the repository name.
+func (cfg cloneConfig) optionsFor(name string) CloneOptions {
+	return CloneOptions{
+		Depth:        cfg.depth,
+		Mirror:       cfg.mirror,
+		AllBranches:  cfg.allBranches && !cfg.mirror,
+		SingleBranch: cfg.singleBranch,
+		Ref:          cfg.refFor(name).String(),
+		LFS:          cfg.fetchLFS,
+		Submodules:   cfg.submodules && !cfg.mirror,
+		StripGit:     cfg.stripGit,
+	}
+}
+
+func (cfg cloneConfig) auth() transport.AuthMethod {
+	if cfg.sshAuth != nil {
+		return cfg.sshAuth
+	}
+	return &tokenAuth{tokens: cfg.tokens}
+}
+
+func (cfg cloneConfig) cloneURL(repo *MinimalRepository) string {
+	if cfg.sshAuth != nil {
+		return repo.SshUrl
+	}
+	return repo.CloneUrl
+}
+
+// cloneRepos starts the cloning workers, which clone repositories sent to the
+// returned channel until it is closed or stop is done, finishing the clone in
+// progress with ctx. Results are collected into the returned manifest, which
+// is complete once wg is done.
+func cloneRepos(ctx, stop context.Context, wg *sync.WaitGroup, cfg cloneConfig, dirFilename string, progress *cloneProgress) (chan<- *MinimalRepository, *Manifest) {
+	work := make(chan *MinimalRepository, perPage)
+	results := make(chan RepoArchiveResult)
+	workersWg := &sync.WaitGroup{}
+
+	for i := range cfg.workers {
+		wg.Add(1)
+		workersWg.Add(1)
+		i := i
+		go func() {
+			defer wg.Done()
+			defer workersWg.Done()
+			slog.Debug("starting worker", "worker", i)
+			for {
+				select {
+				case <-stop.Done():
+					slog.Warn("context done for worker", "worker", i, "err", stop.Err())
+					return
+				case repo, ok := <-work:
+					if stop.Err() != nil {
+						slog.Warn("context done for worker", "worker", i, "err", stop.Err())
+						return
+					}
+					if !ok {
+						slog.Debug("work done for worker", "worker", i)
+						return
+					}
+
+					if cfg.logs != nil {
+						err := cfg.logs.open(repo.Name)
+						if err != nil {
+							slog.Warn("could not open repository log", "repo", repo.Name, "err", err)
+						}
+					}
+					result := cloneRepo(ctx, cfg, dirFilename, repo)
+					// reused clones are hard linked to the previous archive, which
+					// the hook must not modify
+					if len(cfg.postCloneHook) > 0 && result.Status == statusCloned && !result.Reused {
+						runPostCloneHook(ctx, cfg.postCloneHook, dirFilename, repo, &result)
+					}
+					if cfg.stripGit && result.Status == statusCloned && !result.Reused {
+						stripClone(dirFilename, repo, &result)
+					}
+					if cfg.fetchWiki && repo.HasWiki {
+						result.Wiki = cloneWiki(ctx, cfg, dirFilename, repo)
+					}
+					fetchMetadata(ctx, cfg, dirFilename, repo, &result)
+					progress.record(result)
+					recordMetrics(result)
+					if cfg.logs != nil {
+						cfg.logs.close(repo.Name)
+					}
+					results <- result
+				}
+			}
+		}()
+	}
+
+	go func() {
+		workersWg.Wait()
+		close(results)
+	}()
+
+	manifest := &Manifest{Repositories: []RepoArchiveResult{}}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		manifest.collect(results)
+	}()
+
+	return work, manifest
+}
+
+const (
+	// sizeCheckMinBytes is the reported size from which clones are compared
+	// with it, sizeCheckRatio the fraction of it a clone is expected to have.
+	sizeCheckMinBytes = 1 << 20
+	sizeCheckRatio    = 0.5
+)
+
+// reservedEntries are the entries at the root of the archive which are not
+// clones. Reading an archive relies on no clone taking their place.
+var reservedEntries = []string{
+	wikiDir, metadataDir, logsDir,
+	"responses.json", "manifest.json", "summary.json", archiveInfoFile, checksumsFile,
+}
+
+// repoDirectory is the directory the repository name is cloned into, named
+// after the repository rather than the clone URL, which is unique within the
+// owner whatever the URL looks like.
+func repoDirectory(cfg cloneConfig, name string) string {
+	if cfg.mirror {
+		return name + ".git"
+	}
+	return name
+}
+
+// checkRepoDirectories fails for the first of repos which would be cloned
+// into one of reservedEntries. Names are compared ignoring case, which some
+// file systems do.
+func checkRepoDirectories(cfg cloneConfig, repos []*MinimalRepository) error {
+	for _, repo := range repos {
+		dir := repoDirectory(cfg, repo.Name)
+		for _, entry := range reservedEntries {
+			if strings.EqualFold(dir, entry) {
+				return errors.Errorf("repository '%s' would be cloned into '%s', which the archive uses for its own content, exclude it with EXCLUDE_REPOS", repo.Name, dir)
+			}
+		}
+	}
+	return nil
+}
+
+func cloneRepo(ctx context.Context, cfg cloneConfig, dirFilename string, repo *MinimalRepository) RepoArchiveResult {
+	s := cfg.cloneURL(repo)
+	path := repoDirectory(cfg, repo.Name)
+	repoDir := dirFilename + "/" + path
+	pushedAt, _ := repo.PushedAt.(string)
+	options := cfg.optionsFor(repo.Name)
+	result := RepoArchiveResult{Name: repo.Name, Directory: path, PushedAt: pushedAt, CloneOptions: &options}
+	start := time.Now()
+
+	if cfg.previous != nil {
+		reused, ok, err := cfg.previous.reuse(repo, path, repoDir, options)
+		if err != nil {
+			slog.Warn("could not reuse previous clone, cloning again", "repo", repo.Name, "err", err)
+		}
+		if ok {
+			slog.Debug("repository unchanged, previous clone reused", "repo", repo.Name)
+			return reused
+		}
+	}
+
+	slog.Debug("cloning repository", "repo", repo.Name, "url", s)
+	cloneCtx := ctx
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		cloneCtx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+	ref := cfg.refFor(repo.Name)
+	err := cfg.cloner.Clone(cloneCtx, repoDir, s, ref, cfg.auth())
+	result.Duration = time.Since(start)
+	if err != nil && cloneCtx.Err() != nil && ctx.Err() == nil {
+		slog.Error("repository clone timed out, skipping", "repo", repo.Name, "timeout", cfg.timeout)
+		result.Status = statusFailed
+		result.Error = "clone timed out after " + cfg.timeout.String()
+		os.RemoveAll(repoDir)
+		return result
+	}
+	if errors.Is(err, errMissingRef) {
+		slog.Warn("repository has no such ref, skipping", "repo", repo.Name, "ref", ref)
+		result.Status = statusMissingRef
+		result.Error = err.Error()
+		os.RemoveAll(repoDir)
+		return result
+	}
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		slog.Info("repository is empty, skipping", "repo", repo.Name)
+		result.Status = statusEmpty
+		err = markEmpty(repoDir)
+		if err != nil {
+			slog.Error("could not mark repository as empty", "repo", repo.Name, "err", err)
+		}
+		return result
+	}
+	if err != nil {
+		slog.Error("could not clone repository", "repo", repo.Name, "url", s, "duration", result.Duration, "err", err)
+		result.Status = statusFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = statusCloned
+	slog.Debug("repository cloned", "repo", repo.Name, "duration", result.Duration)
+
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		slog.Warn("could not open cloned repository", "repo", repo.Name, "err", err)
+	} else {
+		inspectClone(ctx, cfg, r, repoDir, repo, &result)
+	}
+
+	result.SizeBytes, err = dirSize(repoDir)
+	if err != nil {
+		slog.Warn("could not calculate repository size", "repo", repo.Name, "err", err)
+	}
+	checkCloneSize(cfg, repoDir, repo, &result)
+	return result
+}
+
+// checkCloneSize records the size GitHub reports for repo next to the size of
+// the cloned git objects and warns when the clone is much smaller, which
+// hints at a truncated clone. Shallow clones are expected to be smaller and
+// small repositories vary too much to tell.
+func checkCloneSize(cfg cloneConfig, repoDir string, repo *MinimalRepository, result *RepoArchiveResult) {
+	result.ReportedSizeBytes = int64(repo.Size) * 1024
+	objects, err := dirSize(filepath.Dir(packDir(repoDir)))
+	if err != nil {
+		slog.Warn("could not calculate git objects size", "repo", repo.Name, "err", err)
+		return
+	}
+	result.ObjectsSizeBytes = objects
+
+	if cfg.depth > 0 || result.ReportedSizeBytes < sizeCheckMinBytes {
+		return
+	}
+	if float64(objects) < sizeCheckRatio*float64(result.ReportedSizeBytes) {
+		slog.Warn("clone much smaller than reported by github, it may be incomplete", "repo", repo.Name,
+			"objects_bytes", objects, "reported_bytes", result.ReportedSizeBytes)
+	}
+}
+
+// inspectClone records HEAD and submodules of the cloned repository r in
+// result and fetches its LFS objects when enabled.
+func inspectClone(ctx context.Context, cfg cloneConfig, r *git.Repository, repoDir string, repo *MinimalRepository, result *RepoArchiveResult) {
+	head, err := r.Head()
+	if err != nil {
+		slog.Warn("could not resolve HEAD", "repo", repo.Name, "err", err)
+	} else {
+		result.HeadSHA = head.Hash().String()
+		commit, err := r.CommitObject(head.Hash())
+		if err != nil {
+			slog.Warn("could not read HEAD commit", "repo", repo.Name, "err", err)
+		} else {
+			result.HeadCommitTime = commit.Committer.When
+		}
+	}
+
+	_, hasSubmodules, err := headFile(r, ".gitmodules")
+	if err != nil {
+		slog.Warn("could not detect submodules", "repo", repo.Name, "err", err)
+	}
+	if hasSubmodules {
+		result.Submodules = submodulesNotFetched
+		if cfg.submodules && !cfg.mirror {
+			result.Submodules = submodulesFetched
+		}
+	}
+
+	if cfg.fetchLFS {
+		result.LFS = fetchRepoLFS(ctx, cfg, r, repoDir, repo)
+	}
+}
+
+func markEmpty(repoDir string) error {
+	err := os.MkdirAll(repoDir, dirMode)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(repoDir, emptyRepoMarker), nil, fileMode)
+}
+
+// fetchRepoLFS fetches LFS objects of repositories using LFS and returns the
+// outcome to be recorded in the manifest, empty when LFS is not used.
+func fetchRepoLFS(ctx context.Context, cfg cloneConfig, r *git.Repository, repoDir string, repo *MinimalRepository) string {
+	lfs, err := usesLFS(r)
+	if err != nil {
+		slog.Warn("could not detect LFS usage", "repo", repo.Name, "err", err)
+		return ""
+	}
+	if !lfs {
+		return ""
+	}
+
+	if !lfsInstalled() {
+		slog.Warn("repository uses LFS but git-lfs is not installed, skipping LFS objects", "repo", repo.Name)
+		return lfsUnavailable
+	}
+
+	err = fetchLFS(ctx, repoDir, cfg.cloneURL(repo), cfg)
+	if err != nil {
+		slog.Error("could not fetch LFS objects", "repo", repo.Name, "err", err)
+		return lfsFailed
+	}
+	slog.Debug("LFS objects fetched", "repo", repo.Name)
+	return lfsFetched
+}
+
+// cloneWiki clones the wiki of repo, a separate git repository on GitHub, into
+// wiki/<repo>. Repositories with the wiki enabled but never written to have no
+// wiki repository, which is not considered a failure.
+func cloneWiki(ctx context.Context, cfg cloneConfig, dirFilename string, repo *MinimalRepository) string {
+	wikiURL := strings.TrimSuffix(cfg.cloneURL(repo), ".git") + ".wiki.git"
+	wikiPath := filepath.Join(dirFilename, wikiDir, repo.Name)
+	cloneCtx := ctx
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		cloneCtx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+	// wikis are cloned with the options of the repositories, only the ref
+	// does not apply as wikis have their own branch
+	err := cfg.cloner.Clone(cloneCtx, wikiPath, wikiURL, "", cfg.auth())
+	if errors.Is(err, transport.ErrRepositoryNotFound) || errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		slog.Debug("repository has no wiki", "repo", repo.Name)
+		os.RemoveAll(wikiPath)
+		return wikiNone
+	}
+	if err != nil && cloneCtx.Err() != nil && ctx.Err() == nil {
+		slog.Error("wiki clone timed out", "repo", repo.Name, "timeout", cfg.timeout)
+		os.RemoveAll(wikiPath)
+		return wikiFailed
+	}
+	if err != nil {
+		slog.Error("could not clone wiki", "repo", repo.Name, "err", err)
+		os.RemoveAll(wikiPath)
+		return wikiFailed
+	}
+	if cfg.stripGit {
+		err = removeGitDirs(wikiPath)
+		if err != nil {
+			slog.Error("could not remove git directory of wiki", "repo", repo.Name, "err", err)
+			return wikiFailed
+		}
+	}
+	slog.Debug("wiki cloned", "repo", repo.Name)
+	return wikiCloned
+}
+
+// fetchMetadata stores API metadata of repo enabled in cfg, fetching each kind
+// of it on cfg.metadataPool. Failures are recorded in result but do not fail
+// the repository, as its code is archived.
+func fetchMetadata(ctx context.Context, cfg cloneConfig, dirFilename string, repo *MinimalRepository, result *RepoArchiveResult) {
+	dir := repoMetadataDir(dirFilename, repo)
+	kinds := []struct {
+		enabled bool
+		count   *int
+		task    fetchTask[int]
+	}{
+		{cfg.fetchReleases, &result.Releases, fetchTask[int]{"releases", func(ctx context.Context) (int, error) {
+			return fetchReleases(ctx, cfg.api, dir, repo)
+		}}},
+		{cfg.fetchIssues && repo.HasIssues, &result.Issues, fetchTask[int]{"issues", func(ctx context.Context) (int, error) {
+			return fetchIssues(ctx, cfg.api, dir, repo)
+		}}},
+		{cfg.fetchPulls, &result.Pulls, fetchTask[int]{"pull requests", func(ctx context.Context) (int, error) {
+			return fetchPulls(ctx, cfg.api, dir, repo)
+		}}},
+		{cfg.fetchCollaborators, &result.Collaborators, fetchTask[int]{"collaborators", func(ctx context.Context) (int, error) {
+			return fetchAccess(ctx, cfg.api, dir, repo)
+		}}},
+		{cfg.fetchWebhooks, &result.Webhooks, fetchTask[int]{"webhooks", func(ctx context.Context) (int, error) {
+			return fetchWebhooks(ctx, cfg.api, dir, repo)
+		}}},
+		{cfg.fetchBranchProtection, &result.ProtectedBranches, fetchTask[int]{"branch protection", func(ctx context.Context) (int, error) {
+			return fetchBranchProtection(ctx, cfg.api, dir, repo)
+		}}},
+	}
+
+	tasks := []fetchTask[int]{}
+	counts := []*int{}
+	for _, kind := range kinds {
+		if kind.enabled {
+			tasks = append(tasks, kind.task)
+			counts = append(counts, kind.count)
+		}
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	found, errs := cfg.metadataPool.run(ctx, tasks)
+	for i, task := range tasks {
+		*counts[i] = found[i]
+		if errs[i] != nil {
+			slog.Error("could not archive "+task.name, "repo", repo.Name, "err", errs[i])
+			result.MetadataErrors = append(result.MetadataErrors, errs[i].Error())
+		}
+	}
+}
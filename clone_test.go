@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+// fakeCloner records the directories it clones into, creating them with a
+// single file unless err is set.
+type fakeCloner struct {
+	err  error
+	mu   sync.Mutex
+	dirs map[string]string
+}
+
+func (c *fakeCloner) Clone(_ context.Context, dir, url string, _ plumbing.ReferenceName, _ transport.AuthMethod) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dirs == nil {
+		c.dirs = map[string]string{}
+	}
+	c.dirs[url] = dir
+	if c.err != nil {
+		return c.err
+	}
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "README"), []byte(url), fileMode)
+}
+
+func TestCloneRepoEmpty(t *testing.T) {
+	dir := t.TempDir()
+	cfg := cloneConfig{cloner: &fakeCloner{err: transport.ErrEmptyRemoteRepository}}
+	repo := testRepo(1, "empty")
+	repo.CloneUrl = "https://github.com/org/empty.git"
+
+	result := cloneRepo(context.Background(), cfg, dir, repo)
+	if result.Status != statusEmpty {
+		t.Fatalf("status %q, want %q", result.Status, statusEmpty)
+	}
+	_, err := os.Stat(filepath.Join(dir, "empty", emptyRepoMarker))
+	if err != nil {
+		t.Errorf("empty marker missing: %v", err)
+	}
+
+	manifest := &Manifest{Repositories: []RepoArchiveResult{result}}
+	if failed := manifest.failed(); len(failed) > 0 {
+		t.Errorf("failed repositories %v, want none", failed)
+	}
+	summary := newRunSummary(1, 0, manifest, time.Second, time.Second)
+	if summary.ReposFailed != 0 || summary.ReposSkipped != 1 {
+		t.Errorf("failed %d and skipped %d, want 0 and 1", summary.ReposFailed, summary.ReposSkipped)
+	}
+}
+
+func TestCloneRepoDirectoryFromName(t *testing.T) {
+	dir := t.TempDir()
+	cloner := &fakeCloner{}
+	cfg := cloneConfig{cloner: cloner}
+	// both URLs end with the same path, which would have given the same
+	// directory when it was derived from the URL
+	one := testRepo(1, "one")
+	one.CloneUrl = "https://github.com/org/same.git"
+	two := testRepo(2, "two")
+	two.CloneUrl = "https://github.com/other/same.git"
+
+	for _, repo := range []*MinimalRepository{one, two} {
+		result := cloneRepo(context.Background(), cfg, dir, repo)
+		if result.Status != statusCloned {
+			t.Fatalf("status of %s %q, want %q", repo.Name, result.Status, statusCloned)
+		}
+		if result.Directory != repo.Name {
+			t.Errorf("directory %q, want %q", result.Directory, repo.Name)
+		}
+		if got, want := cloner.dirs[repo.CloneUrl], filepath.Join(dir, repo.Name); filepath.Clean(got) != want {
+			t.Errorf("cloned %s into %q, want %q", repo.CloneUrl, got, want)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, repo.Name, "README"))
+		if err != nil || string(content) != repo.CloneUrl {
+			t.Errorf("clone of %s holds %q, %v", repo.Name, content, err)
+		}
+	}
+}
+
+func TestCloneWiki(t *testing.T) {
+	repo := testRepo(1, "one")
+	repo.CloneUrl = "https://github.com/org/one.git"
+	wikiURL := "https://github.com/org/one.wiki.git"
+
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, wikiCloned},
+		{transport.ErrRepositoryNotFound, wikiNone},
+		{errors.New("connection reset"), wikiFailed},
+	} {
+		dir := t.TempDir()
+		// as left behind by a clone failing halfway
+		err := os.MkdirAll(filepath.Join(dir, wikiDir, repo.Name, ".git"), dirMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cloner := &fakeCloner{err: tc.err}
+		got := cloneWiki(context.Background(), cloneConfig{cloner: cloner}, dir, repo)
+		if got != tc.want {
+			t.Errorf("wiki %q with clone error %v, want %q", got, tc.err, tc.want)
+		}
+		if got, want := cloner.dirs[wikiURL], filepath.Join(dir, wikiDir, repo.Name); got != want {
+			t.Errorf("cloned %s into %q, want %q", wikiURL, got, want)
+		}
+		_, err = os.Stat(filepath.Join(dir, wikiDir, repo.Name))
+		if exists := err == nil; exists != (tc.want == wikiCloned) {
+			t.Errorf("wiki directory left with clone error %v: %t", tc.err, exists)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+// cloner clones the repository at url into dir, only ref when it is set.
+// Empty remote repositories are reported with
+// transport.ErrEmptyRemoteRepository, a missing ref with errMissingRef.
+type cloner interface {
+	Clone(ctx context.Context, dir, url string, ref plumbing.ReferenceName, auth transport.AuthMethod) error
+}
+
+var errMissingRef = errors.New("requested ref not found")
+
+// gitCloner clones with go-git according to the clone options of cloneConfig.
+type gitCloner struct {
+	depth       int
+	mirror      bool
+	allBranches bool
+	submodules  bool
+	// singleBranch skips refs other than the default branch.
+	singleBranch bool
+}
+
+func newGitCloner(cfg cloneConfig) *gitCloner {
+	return &gitCloner{
+		depth:        cfg.depth,
+		mirror:       cfg.mirror,
+		allBranches:  cfg.allBranches,
+		submodules:   cfg.submodules,
+		singleBranch: cfg.singleBranch,
+	}
+}
+
+func (c *gitCloner) Clone(ctx context.Context, dir, url string, ref plumbing.ReferenceName, auth transport.AuthMethod) error {
+	opts := &git.CloneOptions{
+		URL:    url,
+		Auth:   auth,
+		Depth:  c.depth,
+		Mirror: c.mirror,
+	}
+	if ref != "" {
+		opts.ReferenceName = ref
+		opts.SingleBranch = true
+	} else if c.singleBranch {
+		// the remote HEAD, which is the default branch
+		opts.SingleBranch = true
+	}
+	if c.fetchesSubmodules() {
+		opts.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+	r, err := git.PlainCloneContext(ctx, dir, c.mirror, opts)
+	if ref != "" && (errors.Is(err, plumbing.ErrReferenceNotFound) || errors.Is(err, git.NoMatchingRefSpecError{})) {
+		return errors.Wrapf(errMissingRef, "'%s'", ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.allBranches && !c.mirror {
+		err = r.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: []config.RefSpec{allBranchesRefSpec},
+			Auth:     auth,
+			Depth:    c.depth,
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return errors.Wrap(err, "could not fetch all branches")
+		}
+	}
+	return nil
+}
+
+// fetchesSubmodules reports whether submodules are cloned too, which mirrors
+// do not support as they have no worktree.
+func (c *gitCloner) fetchesSubmodules() bool {
+	return c.submodules && !c.mirror
+}
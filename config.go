@@ -0,0 +1,662 @@
+package main
+
+import (
+	"compress/flate"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/pkg/errors"
+)
+
+// runConfig holds everything a run needs, see configFromEnv for the env vars
+// backing each field.
+type runConfig struct {
+	// org is the organisation or user archived by run, one of orgs.
+	org       string
+	orgs      []string
+	api       *apiClient
+	reposPath string
+	timeout   time.Duration
+
+	// reposQuery holds the listing parameters sent for every page of
+	// repositories.
+	reposQuery url.Values
+	// fromManifest, when set, is the responses.json of a previous run, or
+	// its working directory, listing the repositories instead of the API.
+	fromManifest string
+
+	filters []repoFilter
+	// schedule is the order repositories are cloned in, scheduleListing or
+	// scheduleSize.
+	schedule string
+	// maxRepos limits how many of the repositories passing filters are
+	// archived, 0 archives all of them.
+	maxRepos int
+	// listSelection logs every selected repository, set when repositories
+	// are picked by name.
+	listSelection bool
+	// perRepoLogs copies log records of every repository to logs/<repo>.log.
+	perRepoLogs bool
+
+	dryRun bool
+	// reportDuplicates looks for git objects archived more than once.
+	reportDuplicates    bool
+	metricsAddr         string
+	showProgress        bool
+	diskSpaceMultiplier float64
+	noZip               bool
+	archiveFormat       string
+	compressionLevel    int
+	compressionWorkers  int
+	mtimeMode           string
+	// maxArchiveSize splits the archive into volumes of about that many
+	// bytes, 0 writes a single archive.
+	maxArchiveSize int64
+	// ignore excludes matching paths of repositories from the archive.
+	ignore gitignore.Matcher
+	// toStdout streams the archive to stdout instead of a file.
+	toStdout bool
+	// outputName replaces the timestamped name of the working directory
+	// and archive, force allows it to replace a previous output.
+	outputName string
+	force      bool
+	// recipient, when set, encrypts the archive with age.
+	recipient age.Recipient
+	upload    uploadConfig
+
+	clone cloneConfig
+}
+
+func configFromEnv() (runConfig, error) {
+	env := &envParser{}
+	orgs := []string{}
+	for _, org := range listEnv("ORG") {
+		if !slices.Contains(orgs, org) {
+			orgs = append(orgs, org)
+		}
+	}
+	if len(orgs) == 0 {
+		return runConfig{}, errors.New("ORG env expected")
+	}
+
+	apiURL := defaultAPIURL
+	if baseURL := getenv("GITHUB_BASE_URL"); baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return runConfig{}, errors.Errorf("GITHUB_BASE_URL env expected to be an absolute url, got '%s'", baseURL)
+		}
+		apiURL = strings.TrimSuffix(baseURL, "/")
+	}
+
+	var tlsConfig *tls.Config
+	caCertFile := getenv("CA_CERT_FILE")
+	if caCertFile != "" {
+		pool, err := loadCACerts(caCertFile)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "CA_CERT_FILE env expected to point to a PEM certificate bundle")
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+	insecureSkipTLSVerify := env.bool("INSECURE_SKIP_TLS_VERIFY", false)
+	if insecureSkipTLSVerify {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.InsecureSkipVerify = true
+		slog.Warn("TLS CERTIFICATES ARE NOT VERIFIED, INSECURE_SKIP_TLS_VERIFY is only meant for testing against self-signed servers")
+	}
+	client := newHTTPClient(tlsConfig)
+	useForGit(client)
+	if proxy, err := proxyFor(apiURL); err != nil {
+		return runConfig{}, errors.Wrap(err, "HTTPS_PROXY and HTTP_PROXY env expected to be urls")
+	} else if proxy != nil {
+		slog.Info("sending requests through proxy", "proxy", proxy.Redacted())
+	}
+
+	concurrency := env.positiveInt("API_CONCURRENCY", apiConcurrency)
+	// the tokens of a GitHub App are minted through api itself
+	api := newAPIClient(apiURL, client, nil, concurrency)
+	tokens, err := tokenSourceFromEnv(api)
+	if err != nil {
+		return runConfig{}, err
+	}
+	api.tokens = tokens
+
+	reposPath := orgReposPath
+	switch accountType := getenv("ACCOUNT_TYPE"); accountType {
+	case "", "org":
+	case "user":
+		reposPath = userReposPath
+	default:
+		return runConfig{}, errors.Errorf("ACCOUNT_TYPE env expected to be 'org' or 'user', got '%s'", accountType)
+	}
+
+	reposQuery := url.Values{}
+	sort := envOr("REPOS_SORT", "full_name")
+	if !slices.Contains([]string{"created", "updated", "pushed", "full_name"}, sort) {
+		return runConfig{}, errors.Errorf("REPOS_SORT env expected to be one of created, updated, pushed or full_name, got '%s'", sort)
+	}
+	reposQuery.Set("sort", sort)
+	direction := envOr("REPOS_DIRECTION", "asc")
+	if direction != "asc" && direction != "desc" {
+		return runConfig{}, errors.Errorf("REPOS_DIRECTION env expected to be asc or desc, got '%s'", direction)
+	}
+	reposQuery.Set("direction", direction)
+
+	repoTypes := []string{"all", "public", "private", "forks", "sources", "member"}
+	if reposPath == userReposPath {
+		repoTypes = []string{"all", "owner", "member"}
+	}
+	repoType := envOr("REPO_TYPE", "all")
+	if !slices.Contains(repoTypes, repoType) {
+		return runConfig{}, errors.Errorf("REPO_TYPE env expected to be one of %s, got '%s'", strings.Join(repoTypes, ", "), repoType)
+	}
+	reposQuery.Set("type", repoType)
+
+	visibilities := listEnv("VISIBILITY")
+	for _, visibility := range visibilities {
+		if visibility != "public" && visibility != "private" && visibility != "internal" {
+			return runConfig{}, errors.Errorf("VISIBILITY env expected to list public, private or internal, got '%s'", visibility)
+		}
+	}
+	if len(visibilities) == 1 && visibilities[0] != "internal" && reposPath == orgReposPath && repoType == "all" {
+		// the API lists repositories of a single visibility itself, internal
+		// ones are only told apart by the filter
+		reposQuery.Set("type", visibilities[0])
+	}
+
+	includeArchived := env.bool("INCLUDE_ARCHIVED", true)
+	excludeForks := env.bool("EXCLUDE_FORKS", false)
+	topics := listEnv("FILTER_TOPICS")
+	languages := listEnv("FILTER_LANGUAGE")
+	includeRepos := listEnv("INCLUDE_REPOS")
+	excludeRepos := listEnv("EXCLUDE_REPOS")
+	for key, patterns := range map[string][]string{"INCLUDE_REPOS": includeRepos, "EXCLUDE_REPOS": excludeRepos} {
+		err := validatePatterns(patterns)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, key+" env expected to hold valid patterns")
+		}
+	}
+	archiveFormat := getenv("ARCHIVE_FORMAT")
+	switch archiveFormat {
+	case "":
+		archiveFormat = formatZip
+	case formatZip, formatTarGz:
+	default:
+		return runConfig{}, errors.Errorf("ARCHIVE_FORMAT env expected to be '%s' or '%s', got '%s'", formatZip, formatTarGz, archiveFormat)
+	}
+
+	mtimeMode := getenv("ARCHIVE_MTIME")
+	switch mtimeMode {
+	case "", mtimeCheckout, mtimeCommit:
+	default:
+		return runConfig{}, errors.Errorf("ARCHIVE_MTIME env expected to be '%s' or '%s', got '%s'", mtimeCheckout, mtimeCommit, mtimeMode)
+	}
+
+	ignore, err := loadArchiveIgnore(archiveIgnoreFile)
+	if err != nil {
+		return runConfig{}, err
+	}
+	if ignore != nil {
+		slog.Info("excluding paths matching ignore file from the archive", "file", archiveIgnoreFile)
+	}
+
+	toStdout := false
+	switch output := getenv("OUTPUT"); output {
+	case "":
+	case stdoutOutput:
+		toStdout = true
+	default:
+		return runConfig{}, errors.Errorf("OUTPUT env expected to be '%s' when set, got '%s'", stdoutOutput, output)
+	}
+
+	var recipient age.Recipient
+	if key := getenv("ENCRYPT_RECIPIENT"); key != "" {
+		recipient, err = age.ParseX25519Recipient(key)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "ENCRYPT_RECIPIENT env expected to be an age public key like age1...")
+		}
+	}
+
+	cloneCfg := cloneConfig{
+		workers:               positiveIntEnvOrDefault("CLONE_WORKERS", cloningWorkers),
+		depth:                 env.positiveInt("CLONE_DEPTH", 0),
+		mirror:                env.bool("MIRROR", false),
+		allBranches:           env.bool("ALL_BRANCHES", false),
+		fetchLFS:              env.bool("FETCH_LFS", false),
+		submodules:            env.bool("RECURSE_SUBMODULES", false),
+		api:                   api,
+		metadataPool:          newFetchWorkerPool[int](concurrency),
+		fetchReleases:         env.bool("FETCH_RELEASES", false),
+		fetchIssues:           env.bool("FETCH_ISSUES", false),
+		fetchPulls:            env.bool("FETCH_PULLS", false),
+		fetchWiki:             env.bool("FETCH_WIKI", false),
+		tokens:                tokens,
+		ref:                   getenv("CLONE_REF"),
+		postCloneHook:         strings.Fields(getenv("POST_CLONE_HOOK")),
+		timeout:               env.duration("CLONE_TIMEOUT", 0),
+		caCertFile:            caCertFile,
+		insecureSkipTLSVerify: insecureSkipTLSVerify,
+		singleBranch:          env.bool("SINGLE_BRANCH", false),
+		fetchCollaborators:    env.bool("FETCH_COLLABORATORS", false),
+		fetchWebhooks:         env.bool("FETCH_WEBHOOKS", false),
+		fetchBranchProtection: env.bool("FETCH_BRANCH_PROTECTION", false),
+		stripGit:              env.bool("STRIP_GIT", false),
+	}
+	if cloneCfg.stripGit && cloneCfg.mirror {
+		return runConfig{}, errors.New("STRIP_GIT env cannot be combined with MIRROR, mirrors have no checked out files")
+	}
+	if cloneCfg.singleBranch && (cloneCfg.mirror || cloneCfg.allBranches) {
+		return runConfig{}, errors.New("SINGLE_BRANCH env cannot be combined with MIRROR or ALL_BRANCHES")
+	}
+	if refFile := getenv("CLONE_REF_FILE"); refFile != "" {
+		content, err := os.ReadFile(refFile)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "could not read CLONE_REF_FILE")
+		}
+		err = json.Unmarshal(content, &cloneCfg.refs)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "CLONE_REF_FILE env expected to point to a JSON object of repository names to refs")
+		}
+	}
+	if (cloneCfg.ref != "" || len(cloneCfg.refs) > 0) && (cloneCfg.mirror || cloneCfg.allBranches) {
+		return runConfig{}, errors.New("CLONE_REF and CLONE_REF_FILE env cannot be combined with MIRROR or ALL_BRANCHES")
+	}
+	cloneCfg.cloner = newGitCloner(cloneCfg)
+	if previousDir := getenv("INCREMENTAL"); previousDir != "" {
+		previous, err := loadPreviousArchive(previousDir)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "could not load INCREMENTAL archive")
+		}
+		cloneCfg.previous = previous
+		slog.Info("incremental mode, reusing unchanged repositories", "previous", previousDir)
+	}
+	cloneToken := getenv("CLONE_TOKEN")
+	if sshKeyPath := getenv("SSH_KEY_PATH"); sshKeyPath != "" {
+		if cloneToken != "" {
+			return runConfig{}, errors.New("CLONE_TOKEN env cannot be combined with SSH_KEY_PATH")
+		}
+		auth, err := ssh.NewPublicKeysFromFile("git", sshKeyPath, getenv("SSH_KEY_PASSWORD"))
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "could not load SSH key from SSH_KEY_PATH")
+		}
+		cloneCfg.sshAuth = auth
+		cloneCfg.sshKeyPath = sshKeyPath
+		slog.Info("cloning over SSH, token is used for the API only", "key", sshKeyPath)
+	} else if cloneToken != "" {
+		// the API keeps using tokens
+		cloneCfg.tokens = staticToken(cloneToken)
+		slog.Info("cloning over HTTPS with CLONE_TOKEN, token is used for the API only")
+	} else {
+		slog.Info("cloning over HTTPS with token")
+	}
+	if cloneCfg.depth > 0 {
+		slog.Warn("shallow mode active, history beyond the clone depth will not be archived", "depth", cloneCfg.depth)
+	}
+
+	filters := []repoFilter{}
+	if !includeArchived {
+		filters = append(filters, excludeArchived())
+	}
+	if excludeForks {
+		filters = append(filters, excludeForked())
+	}
+	if len(visibilities) > 0 {
+		filters = append(filters, withVisibility(visibilities))
+	}
+	if len(topics) > 0 {
+		filters = append(filters, withTopics(topics))
+	}
+	if len(languages) > 0 {
+		filters = append(filters, withLanguages(languages))
+	}
+	if len(includeRepos) > 0 {
+		filters = append(filters, includeNames(includeRepos))
+	}
+	if len(excludeRepos) > 0 {
+		filters = append(filters, excludeNames(excludeRepos))
+	}
+	if cutoff, ok := env.time("PUSHED_BEFORE"); ok {
+		filters = append(filters, pushedBefore(cutoff))
+	}
+	if cutoff, ok := env.time("PUSHED_AFTER"); ok {
+		filters = append(filters, pushedAfter(cutoff))
+	}
+	if id := env.positiveInt("SINCE_REPO_ID", 0); id > 0 {
+		// unlike /repositories the repos listings of owners have no since
+		// cursor, every page is still fetched
+		filters = append(filters, sinceID(id))
+	}
+	if source := getenv("FILTER_EXPR"); source != "" {
+		filter, err := compileFilterExpr(source)
+		if err != nil {
+			return runConfig{}, errors.Wrap(err, "FILTER_EXPR env expected to be a valid expression")
+		}
+		filters = append(filters, filter)
+	}
+	if limit := env.byteSize("MAX_REPO_SIZE"); limit > 0 {
+		action := envOr("MAX_REPO_SIZE_ACTION", "skip")
+		if action != "skip" && action != "warn" {
+			return runConfig{}, errors.Errorf("MAX_REPO_SIZE_ACTION env expected to be skip or warn, got '%s'", action)
+		}
+		filters = append(filters, maxSize(limit, action == "warn"))
+	}
+
+	cfg := runConfig{
+		org:                 orgs[0],
+		orgs:                orgs,
+		api:                 api,
+		reposPath:           reposPath,
+		reposQuery:          reposQuery,
+		fromManifest:        getenv("FROM_MANIFEST"),
+		maxRepos:            env.positiveInt("MAX_REPOS", 0),
+		schedule:            envOr("SCHEDULE", scheduleListing),
+		timeout:             env.duration("TIMEOUT", programTimeout),
+		filters:             filters,
+		listSelection:       len(includeRepos) > 0 || len(excludeRepos) > 0,
+		perRepoLogs:         env.bool("PER_REPO_LOGS", false),
+		dryRun:              env.bool("DRY_RUN", false),
+		reportDuplicates:    env.bool("REPORT_DUPLICATES", false),
+		metricsAddr:         getenv("METRICS_ADDR"),
+		showProgress:        env.bool("PROGRESS", false),
+		diskSpaceMultiplier: env.float("DISK_SPACE_MULTIPLIER", defaultDiskSpaceMultiplier),
+		noZip:               env.bool("NO_ZIP", false),
+		archiveFormat:       archiveFormat,
+		compressionLevel:    rangeIntEnvOrDefault("COMPRESSION_LEVEL", flate.DefaultCompression, flate.DefaultCompression, flate.BestCompression),
+		compressionWorkers:  env.positiveInt("COMPRESSION_WORKERS", 1),
+		mtimeMode:           mtimeMode,
+		maxArchiveSize:      env.byteSize("MAX_ARCHIVE_SIZE"),
+		ignore:              ignore,
+		toStdout:            toStdout,
+		outputName:          getenv("OUTPUT_NAME"),
+		recipient:           recipient,
+		upload: uploadConfig{
+			s3: s3Config{
+				bucket:   getenv("S3_BUCKET"),
+				endpoint: getenv("S3_ENDPOINT"),
+			},
+			gcsBucket: getenv("GCS_BUCKET"),
+			localDir:  getenv("UPLOAD_DIR"),
+			// S3_DELETE_LOCAL predates uploads to other destinations
+			deleteLocal: env.bool("DELETE_AFTER_UPLOAD", env.bool("S3_DELETE_LOCAL", false)),
+		},
+		clone: cloneCfg,
+	}
+	if env.err != nil {
+		return runConfig{}, env.err
+	}
+	if cfg.toStdout && (cfg.noZip || cfg.maxArchiveSize > 0 || cfg.upload.enabled()) {
+		return runConfig{}, errors.New("OUTPUT env set to stdout cannot be combined with NO_ZIP, MAX_ARCHIVE_SIZE or uploads")
+	}
+	if len(orgs) > 1 && (cfg.toStdout || cloneCfg.previous != nil || cfg.outputName != "" || cfg.fromManifest != "") {
+		return runConfig{}, errors.New("ORG env with several organisations cannot be combined with OUTPUT set to stdout, OUTPUT_NAME, INCREMENTAL or FROM_MANIFEST")
+	}
+	if cfg.reportDuplicates && cloneCfg.stripGit {
+		return runConfig{}, errors.New("REPORT_DUPLICATES env cannot be combined with STRIP_GIT, no git objects are kept")
+	}
+	if cfg.schedule != scheduleListing && cfg.schedule != scheduleSize {
+		return runConfig{}, errors.Errorf("SCHEDULE env expected to be '%s' or '%s', got '%s'", scheduleListing, scheduleSize, cfg.schedule)
+	}
+	if strings.ContainsRune(cfg.outputName, os.PathSeparator) {
+		return runConfig{}, errors.Errorf("OUTPUT_NAME env expected to be a file name, got '%s'", cfg.outputName)
+	}
+	return cfg, nil
+}
+
+// tokenSourceFromEnv authenticates as a GitHub App installation when
+// GITHUB_APP_ID is set, with a personal access token otherwise.
+func tokenSourceFromEnv(api *apiClient) (tokenSource, error) {
+	if appID := getenv("GITHUB_APP_ID"); appID != "" {
+		installationID := getenv("GITHUB_APP_INSTALLATION_ID")
+		if installationID == "" {
+			return nil, errors.New("GITHUB_APP_INSTALLATION_ID env expected together with GITHUB_APP_ID")
+		}
+
+		privateKey := []byte(getenv("GITHUB_APP_PRIVATE_KEY"))
+		if keyFile := getenv("GITHUB_APP_PRIVATE_KEY_FILE"); keyFile != "" {
+			b, err := os.ReadFile(keyFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not read GITHUB_APP_PRIVATE_KEY_FILE")
+			}
+			privateKey = b
+		}
+		if len(privateKey) == 0 {
+			return nil, errors.New("GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_FILE env expected together with GITHUB_APP_ID")
+		}
+
+		tokens, err := newAppTokenSource(api, appID, installationID, privateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid GitHub App private key")
+		}
+		slog.Info("authenticating as GitHub App installation", "app_id", appID, "installation_id", installationID)
+		return tokens, nil
+	}
+
+	if tokenFile := getenv("GITHUB_TOKEN_FILE"); tokenFile != "" {
+		token, err := readTokenFile(tokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read GITHUB_TOKEN_FILE")
+		}
+		return fileToken(token), nil
+	}
+	githubToken := getenv("GITHUB_TOKEN")
+	if githubToken == "" {
+		return nil, errors.New("GITHUB_TOKEN or GITHUB_TOKEN_FILE env expected")
+	}
+	return staticToken(githubToken), nil
+}
+
+func readTokenFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", errors.Errorf("token file '%s' is empty", path)
+	}
+	return token, nil
+}
+
+// envParser reads options with the env helpers below, keeping the first
+// invalid value as err so that a whole configuration can be read before
+// checking it once. Invalid values read as their default meanwhile.
+type envParser struct {
+	err error
+}
+
+func (p *envParser) keep(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *envParser) bool(key string, def bool) bool {
+	v, err := boolEnv(key, def)
+	p.keep(err)
+	return v
+}
+
+func (p *envParser) time(key string) (time.Time, bool) {
+	t, ok, err := timeEnv(key)
+	p.keep(err)
+	return t, ok
+}
+
+func (p *envParser) duration(key string, def time.Duration) time.Duration {
+	d, err := durationEnv(key, def)
+	p.keep(err)
+	return d
+}
+
+func (p *envParser) positiveInt(key string, def int) int {
+	i, err := positiveIntEnv(key, def)
+	p.keep(err)
+	return i
+}
+
+func (p *envParser) float(key string, def float64) float64 {
+	f, err := floatEnv(key, def)
+	p.keep(err)
+	return f
+}
+
+func (p *envParser) byteSize(key string) int64 {
+	n, err := byteSizeEnv(key)
+	p.keep(err)
+	return n
+}
+
+func boolEnv(key string, def bool) (bool, error) {
+	v := getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, errors.Errorf("%s env expected to be a boolean, got '%s'", key, v)
+	}
+	return b, nil
+}
+
+// listEnv splits a comma separated env value, ignoring empty items.
+func listEnv(key string) []string {
+	items := []string{}
+	for _, item := range strings.Split(getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envOr returns the value of key, or fallback when it is unset or empty.
+func envOr(key, fallback string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// timeEnv parses a date like 2023-01-01 or an RFC 3339 timestamp.
+func timeEnv(key string) (time.Time, bool, error) {
+	v := getenv(key)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, v)
+	}
+	if err != nil {
+		return time.Time{}, false, errors.Errorf("%s env expected to be a date like 2006-01-02 or RFC 3339 timestamp, got '%s'", key, v)
+	}
+	return t, true, nil
+}
+
+// byteSizeEnv parses a number of bytes with an optional K, M, G or T binary
+// unit suffix, e.g. 500M or 2G. It returns 0 when unset.
+func byteSizeEnv(key string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(getenv(key)))
+	if v == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	if i := strings.IndexAny(v, "KMGT"); i == len(v)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGT", v[i]) + 1))
+		v = v[:i]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("%s env expected to be a positive size like 500M or 2G, got '%s'", key, getenv(key))
+	}
+	return n * multiplier, nil
+}
+
+// durationEnv parses a positive duration like 90s, 10m or 2h.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	v := getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return def, errors.Errorf("%s env expected to be a positive duration like 10m or 2h, got '%s'", key, v)
+	}
+	return d, nil
+}
+
+func positiveIntEnv(key string, def int) (int, error) {
+	v := getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		return def, errors.Errorf("%s env expected to be a positive integer, got '%s'", key, v)
+	}
+	return i, nil
+}
+
+// positiveIntEnvOrDefault is positiveIntEnv for options which fall back to
+// def with a warning rather than failing.
+func positiveIntEnvOrDefault(key string, def int) int {
+	i, err := positiveIntEnv(key, def)
+	if err != nil {
+		slog.Warn(err.Error()+", falling back to default", "default", def)
+	}
+	return i
+}
+
+func floatEnv(key string, def float64) (float64, error) {
+	v := getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def, errors.Errorf("%s env expected to be a number, got '%s'", key, v)
+	}
+	return f, nil
+}
+
+func rangeIntEnv(key string, def, min, max int) (int, error) {
+	v := getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < min || i > max {
+		return def, errors.Errorf("%s env expected to be an integer between %d and %d, got '%s'", key, min, max, v)
+	}
+	return i, nil
+}
+
+// rangeIntEnvOrDefault is rangeIntEnv for options which fall back to def with
+// a warning rather than failing.
+func rangeIntEnvOrDefault(key string, def, min, max int) int {
+	i, err := rangeIntEnv(key, def, min, max)
+	if err != nil {
+		slog.Warn(err.Error()+", falling back to default", "default", def)
+	}
+	return i
+}
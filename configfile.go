@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// fileOptions holds the options of the --config file by variable name, read
+// by getenv when the variable is not set.
+var fileOptions = map[string]string{}
+
+// getenv reads the option key from the environment, falling back to the
+// config file. File options are never exported, so that tokens in it do not
+// reach POST_CLONE_HOOK or git.
+func getenv(key string) string {
+	if v, set := os.LookupEnv(key); set {
+		return v
+	}
+	return fileOptions[key]
+}
+
+// envOnlyOption reports whether name is read from the environment by the
+// standard library, git or the AWS SDK rather than through getenv, so that
+// setting it in the config file would have no effect.
+func envOnlyOption(name string) bool {
+	switch name {
+	case "HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY":
+		return true
+	}
+	return strings.HasPrefix(name, "AWS_")
+}
+
+// loadConfigFile reads options from a YAML or JSON mapping of the variables
+// listed in the README, written in any case, e.g. `include_repos: [infra-*]`.
+// Lists are joined with commas.
+func loadConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+
+	options := map[string]any{}
+	err = yaml.Unmarshal(content, &options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse config file '%s'", path)
+	}
+
+	values := map[string]string{}
+	for key, value := range options {
+		name := strings.ToUpper(key)
+		if envOnlyOption(name) {
+			slog.Warn("config file option ignored, it is only read from the environment", "option", name)
+			continue
+		}
+
+		switch value := value.(type) {
+		case nil:
+			continue
+		case map[string]any:
+			return nil, errors.Errorf("config file option '%s' expected to be a value or a list", key)
+		case []any:
+			items := []string{}
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFileNotExported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte("github_token: from-file\norg: file-org\ninclude_repos: [infra-*, platform]\nhttps_proxy: http://proxy\n"), fileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ORG", "env-org")
+	// restored by Setenv once the test is done
+	for _, key := range []string{"GITHUB_TOKEN", "INCLUDE_REPOS", "HTTPS_PROXY"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	options, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := fileOptions
+	fileOptions = options
+	t.Cleanup(func() { fileOptions = previous })
+
+	for key, want := range map[string]string{
+		"GITHUB_TOKEN":  "from-file",
+		"ORG":           "env-org",
+		"INCLUDE_REPOS": "infra-*,platform",
+		"HTTPS_PROXY":   "",
+	} {
+		if got := getenv(key); got != want {
+			t.Errorf("%s %q, want %q", key, got, want)
+		}
+	}
+	if _, set := os.LookupEnv("GITHUB_TOKEN"); set {
+		t.Error("GITHUB_TOKEN of the config file exported to the environment")
+	}
+}
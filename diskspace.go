@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+const defaultDiskSpaceMultiplier = 3.0
+
+// diskBudget tracks the space estimated for repositories queued for cloning
+// against the free space found on the target filesystem.
+type diskBudget struct {
+	available  uint64
+	reserved   uint64
+	multiplier float64
+}
+
+// newDiskBudget returns nil, which disables the check, when multiplier is
+// not positive or free space cannot be determined.
+func newDiskBudget(dir string, multiplier float64) *diskBudget {
+	if multiplier <= 0 {
+		return nil
+	}
+
+	available, err := freeDiskSpace(dir)
+	if err != nil {
+		slog.Warn("could not determine free disk space, skipping the check", "err", err)
+		return nil
+	}
+	return &diskBudget{available: available, multiplier: multiplier}
+}
+
+// reserve accounts for the estimated size of repo, based on the size reported
+// by the API, and fails when it would not fit into the free space.
+func (b *diskBudget) reserve(repo *MinimalRepository) error {
+	if b == nil {
+		return nil
+	}
+
+	required := uint64(float64(repo.Size) * 1024 * b.multiplier)
+	if b.reserved+required > b.available {
+		return errors.Errorf("insufficient disk space to archive '%s', estimated %d bytes required in total but only %d bytes available",
+			repo.Name, b.reserved+required, b.available)
+	}
+	b.reserved += required
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// freeDiskSpace returns the number of bytes available to unprivileged users
+// on the filesystem holding path.
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
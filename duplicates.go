@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/pkg/errors"
+)
+
+// packTrailerSize is the checksum closing every pack file.
+const packTrailerSize = 20
+
+// DuplicateObjects reports git objects stored by more than one archived
+// repository, typically forks of the same upstream.
+type DuplicateObjects struct {
+	Objects int `json:"objects"`
+	// Bytes is the compressed pack size taken by the copies beyond the
+	// first one, i.e. what sharing the objects would save.
+	Bytes int64 `json:"bytes"`
+}
+
+// findDuplicateObjects reads the pack indexes of all cloned repositories of m
+// and reports objects also found in another of them. Loose objects are not
+// considered, clones keep everything in packs.
+func findDuplicateObjects(dirFilename string, m *Manifest) (DuplicateObjects, error) {
+	// owner maps every object seen to the index of the first repository
+	// holding it
+	owner := map[plumbing.Hash]int{}
+	duplicates := DuplicateObjects{}
+	for i, repo := range m.Repositories {
+		if repo.Status != statusCloned {
+			continue
+		}
+
+		packs, err := filepath.Glob(filepath.Join(packDir(filepath.Join(dirFilename, repo.Directory)), "*.idx"))
+		if err != nil {
+			return DuplicateObjects{}, err
+		}
+		for _, idxPath := range packs {
+			err := readPackObjects(idxPath, func(h plumbing.Hash, size int64) {
+				first, seen := owner[h]
+				if !seen {
+					owner[h] = i
+					return
+				}
+				if first != i {
+					duplicates.Objects++
+					duplicates.Bytes += size
+				}
+			})
+			if err != nil {
+				return DuplicateObjects{}, errors.Wrapf(err, "could not read pack index of '%s'", repo.Name)
+			}
+		}
+	}
+	return duplicates, nil
+}
+
+// packDir locates the pack files of a clone, which mirrors keep directly in
+// the repository directory.
+func packDir(repoDir string) string {
+	dotGit := filepath.Join(repoDir, ".git")
+	if _, err := os.Stat(dotGit); err == nil {
+		repoDir = dotGit
+	}
+	return filepath.Join(repoDir, "objects", "pack")
+}
+
+// readPackObjects calls fn with every object of the pack indexed by idxPath
+// and its compressed size, derived from the offset of the following object.
+func readPackObjects(idxPath string, fn func(h plumbing.Hash, size int64)) error {
+	f, err := os.Open(idxPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idx := idxfile.NewMemoryIndex()
+	err = idxfile.NewDecoder(f).Decode(idx)
+	if err != nil {
+		return err
+	}
+
+	pack, err := os.Stat(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.EntriesByOffset()
+	if err != nil {
+		return err
+	}
+	defer entries.Close()
+
+	var previous *idxfile.Entry
+	for {
+		entry, err := entries.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			fn(previous.Hash, int64(entry.Offset-previous.Offset))
+		}
+		previous = entry
+	}
+	if previous != nil {
+		fn(previous.Hash, pack.Size()-packTrailerSize-int64(previous.Offset))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+
+	"filippo.io/age"
+)
+
+// encryptedExtension is appended to archives encrypted for ENCRYPT_RECIPIENT.
+const encryptedExtension = ".age"
+
+// archiveExtension is the file extension of archives in format, encrypted
+// when recipient is set.
+func archiveExtension(format string, recipient age.Recipient) string {
+	if recipient == nil {
+		return "." + format
+	}
+	return "." + format + encryptedExtension
+}
+
+// encryptTo encrypts everything written to the returned writer for
+// recipient before passing it to w, so that the archive never reaches the
+// disk in plain. A nil recipient writes to w as is.
+func encryptTo(w io.Writer, recipient age.Recipient) (io.WriteCloser, error) {
+	if recipient == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, recipient)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/pkg/errors"
+)
+
+// repoFilter drops repositories for which keep returns false before they
+// reach the cloning stage.
+type repoFilter struct {
+	name string
+	keep func(repo *MinimalRepository) bool
+}
+
+// repoSelector applies filters to pages of repositories as they are fetched,
+// counting how many repositories each filter skipped. The first limit
+// repositories passing the filters are selected, all of them when it is 0.
+type repoSelector struct {
+	filters []repoFilter
+	skipped []int
+
+	limit    int
+	selected int
+	limited  int
+}
+
+func newRepoSelector(filters []repoFilter, limit int) *repoSelector {
+	return &repoSelector{
+		filters: filters,
+		skipped: make([]int, len(filters)),
+		limit:   limit,
+	}
+}
+
+func (s *repoSelector) selectRepos(repos []*MinimalRepository) []*MinimalRepository {
+	for i, filter := range s.filters {
+		kept := make([]*MinimalRepository, 0, len(repos))
+		for _, repo := range repos {
+			if filter.keep(repo) {
+				kept = append(kept, repo)
+			}
+		}
+		s.skipped[i] += len(repos) - len(kept)
+		repos = kept
+	}
+	if s.limit > 0 && s.selected+len(repos) > s.limit {
+		kept := max(s.limit-s.selected, 0)
+		s.limited += len(repos) - kept
+		repos = repos[:kept]
+	}
+	s.selected += len(repos)
+	return repos
+}
+
+func (s *repoSelector) skippedTotal() int {
+	total := s.limited
+	for _, skipped := range s.skipped {
+		total += skipped
+	}
+	return total
+}
+
+func (s *repoSelector) printSummary() {
+	for i, filter := range s.filters {
+		slog.Info("repositories skipped by filter", "filter", filter.name, "count", s.skipped[i])
+	}
+	if s.limited > 0 {
+		summaryLog.Warn("repository limit reached, skipping the remaining repositories", "limit", s.limit, "count", s.limited)
+	}
+}
+
+func excludeArchived() repoFilter {
+	return repoFilter{
+		name: "exclude archived",
+		keep: func(repo *MinimalRepository) bool {
+			return !repo.Archived
+		},
+	}
+}
+
+func excludeForked() repoFilter {
+	return repoFilter{
+		name: "exclude forks",
+		keep: func(repo *MinimalRepository) bool {
+			return !repo.Fork
+		},
+	}
+}
+
+// withVisibility keeps repositories of one of visibilities, public, private or
+// internal.
+func withVisibility(visibilities []string) repoFilter {
+	return repoFilter{
+		name: "visibility " + strings.Join(visibilities, ","),
+		keep: func(repo *MinimalRepository) bool {
+			return slices.Contains(visibilities, repo.visibility())
+		},
+	}
+}
+
+// withTopics keeps repositories tagged with at least one of topics.
+func withTopics(topics []string) repoFilter {
+	return repoFilter{
+		name: "topics",
+		keep: func(repo *MinimalRepository) bool {
+			for _, topic := range repo.Topics {
+				if slices.Contains(topics, topic) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// withLanguages keeps repositories whose primary language is one of
+// languages, compared case-insensitively. Repositories without a detected
+// language are dropped.
+func withLanguages(languages []string) repoFilter {
+	return repoFilter{
+		name: "language",
+		keep: func(repo *MinimalRepository) bool {
+			language, ok := repo.language()
+			if !ok {
+				return false
+			}
+			return slices.ContainsFunc(languages, func(l string) bool {
+				return strings.EqualFold(l, language)
+			})
+		},
+	}
+}
+
+// includeNames keeps repositories whose name matches one of patterns, see
+// path.Match for the pattern syntax.
+func includeNames(patterns []string) repoFilter {
+	return repoFilter{
+		name: "include repos",
+		keep: func(repo *MinimalRepository) bool {
+			return matchesAny(repo.Name, patterns)
+		},
+	}
+}
+
+// excludeNames drops repositories whose name matches one of patterns.
+func excludeNames(patterns []string) repoFilter {
+	return repoFilter{
+		name: "exclude repos",
+		keep: func(repo *MinimalRepository) bool {
+			return !matchesAny(repo.Name, patterns)
+		},
+	}
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		// patterns are validated upfront with validatePatterns
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// pushedBefore keeps repositories last pushed to before cutoff.
+func pushedBefore(cutoff time.Time) repoFilter {
+	return repoFilter{
+		name: "pushed before",
+		keep: func(repo *MinimalRepository) bool {
+			pushedAt, ok := repo.pushedAt()
+			return ok && pushedAt.Before(cutoff)
+		},
+	}
+}
+
+// pushedAfter keeps repositories last pushed to after cutoff.
+func pushedAfter(cutoff time.Time) repoFilter {
+	return repoFilter{
+		name: "pushed after",
+		keep: func(repo *MinimalRepository) bool {
+			pushedAt, ok := repo.pushedAt()
+			return ok && pushedAt.After(cutoff)
+		},
+	}
+}
+
+// maxSize drops repositories GitHub reports as larger than limit bytes, or
+// only warns about them with warnOnly.
+func maxSize(limit int64, warnOnly bool) repoFilter {
+	return repoFilter{
+		name: "max repo size",
+		keep: func(repo *MinimalRepository) bool {
+			if int64(repo.Size)*1024 <= limit {
+				return true
+			}
+			if warnOnly {
+				slog.Warn("repository larger than MAX_REPO_SIZE", "repo", repo.Name, "size_kb", repo.Size)
+				return true
+			}
+			slog.Warn("repository larger than MAX_REPO_SIZE, skipping", "repo", repo.Name, "size_kb", repo.Size)
+			return false
+		},
+	}
+}
+
+// sinceID keeps repositories with an ID greater than id, which were created
+// after the repository with that ID.
+func sinceID(id int) repoFilter {
+	return repoFilter{
+		name: "since repo id",
+		keep: func(repo *MinimalRepository) bool {
+			return repo.Id > id
+		},
+	}
+}
+
+// compileFilterExpr compiles source, an expr-lang expression like
+// `size < 100000 && !archived && language == "Go"`, into a filter keeping
+// repositories for which it is true. Repository fields are named as in
+// responses.json, fields GitHub left out are nil.
+func compileFilterExpr(source string) (repoFilter, error) {
+	// fields are only typed when evaluated, as GitHub leaves out or nulls
+	// some of them
+	program, err := expr.Compile(source, expr.Env(map[string]any{}), expr.AllowUndefinedVariables(), expr.AsBool())
+	if err != nil {
+		return repoFilter{}, err
+	}
+
+	return repoFilter{
+		name: "expression",
+		keep: func(repo *MinimalRepository) bool {
+			env, err := exprEnv(repo)
+			if err == nil {
+				var keep any
+				keep, err = expr.Run(program, env)
+				if err == nil {
+					return keep.(bool)
+				}
+			}
+			slog.Warn("could not evaluate FILTER_EXPR, skipping repository", "repo", repo.Name, "err", err.Error())
+			return false
+		},
+	}, nil
+}
+
+// exprEnv exposes the JSON fields of repo to expressions.
+func exprEnv(repo *MinimalRepository) (map[string]any, error) {
+	j, err := json.Marshal(repo)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]any{}
+	err = json.Unmarshal(j, &env)
+	return env, err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testRepo is a repository the generated JSON code accepts, as it requires an
+// owner.
+func testRepo(id int, name string) *MinimalRepository {
+	return &MinimalRepository{Id: id, Name: name, Owner: &SimpleUser{}}
+}
+
+func TestExcludeForked(t *testing.T) {
+	source := testRepo(1, "source")
+	fork := testRepo(2, "fork")
+	fork.Fork = true
+
+	filter := excludeForked()
+	if !filter.keep(source) {
+		t.Error("source repository dropped")
+	}
+	if filter.keep(fork) {
+		t.Error("fork kept")
+	}
+}
+
+func TestRepoSelectorSkipsForks(t *testing.T) {
+	page := []*MinimalRepository{testRepo(1, "one"), testRepo(2, "fork"), testRepo(3, "two")}
+	page[1].Fork = true
+	for _, repo := range page {
+		repo.CloneUrl = "https://github.com/org/" + repo.Name + ".git"
+	}
+
+	cloner := &fakeCloner{}
+	cfg := cloneConfig{workers: 2, cloner: cloner}
+	wg := &sync.WaitGroup{}
+	ctx := context.Background()
+	work, manifest := cloneRepos(ctx, ctx, wg, cfg, t.TempDir(), &cloneProgress{})
+	selector := newRepoSelector([]repoFilter{excludeForked()}, 0)
+	for _, repo := range selector.selectRepos(page) {
+		work <- repo
+	}
+	close(work)
+	wg.Wait()
+
+	if _, ok := cloner.dirs[page[1].CloneUrl]; ok || len(cloner.dirs) != 2 {
+		t.Errorf("cloned %v, want one and two only", cloner.dirs)
+	}
+	archived := []string{}
+	for _, result := range manifest.Repositories {
+		archived = append(archived, result.Name)
+	}
+	slices.Sort(archived)
+	if want := []string{"one", "two"}; !slices.Equal(archived, want) {
+		t.Errorf("archived %v, want %v", archived, want)
+	}
+	if got := selector.skippedTotal(); got != 1 {
+		t.Errorf("skipped %d, want 1", got)
+	}
+}
+
+func repoNames(repos []*MinimalRepository) []string {
+	names := []string{}
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	return names
+}
+
+func TestRepoSelectorLimit(t *testing.T) {
+	archived := testRepo(2, "archived")
+	archived.Archived = true
+	selector := newRepoSelector([]repoFilter{excludeArchived()}, 2)
+
+	first := selector.selectRepos([]*MinimalRepository{testRepo(1, "one"), archived})
+	second := selector.selectRepos([]*MinimalRepository{testRepo(3, "two"), testRepo(4, "three")})
+	third := selector.selectRepos([]*MinimalRepository{testRepo(5, "four")})
+
+	got := repoNames(append(append(first, second...), third...))
+	if want := []string{"one", "two"}; !slices.Equal(got, want) {
+		t.Errorf("selected %v, want %v", got, want)
+	}
+	if selector.limited != 2 {
+		t.Errorf("limited %d, want 2", selector.limited)
+	}
+	if got := selector.skippedTotal(); got != 3 {
+		t.Errorf("skipped %d, want 3", got)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// apiClient sends requests to the GitHub API at baseURL, authenticated with
+// tokens. Both client and baseURL can point to a test server.
+type apiClient struct {
+	baseURL string
+	client  *http.Client
+	tokens  tokenSource
+	// sem bounds the number of requests in flight across all workers.
+	sem chan struct{}
+}
+
+func newAPIClient(baseURL string, client *http.Client, tokens tokenSource, concurrency int) *apiClient {
+	return &apiClient{
+		baseURL: baseURL,
+		client:  client,
+		tokens:  tokens,
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+// doAPIRequest performs r once one of the concurrent request slots is free.
+// Every request to the API goes through it.
+func (api *apiClient) doAPIRequest(ctx context.Context, r *http.Request) (*http.Response, error) {
+	select {
+	case api.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "context finished while waiting for a request slot")
+	}
+	defer func() { <-api.sem }()
+
+	return doRateLimited(ctx, api.client, r)
+}
+
+// fetchReposData pages through all repositories of owner listed with query,
+// handing every decoded page to onPage before requesting the next one. A
+// fixed sort order in query keeps pages from shifting while they are fetched.
+func fetchReposData(ctx context.Context, api *apiClient, reposPath string, owner string, query url.Values, onPage func([]*MinimalRepository)) ([]*MinimalRepository, error) {
+	repos := []*MinimalRepository{}
+	reposURL := api.baseURL + fmt.Sprintf(reposPath, owner)
+	if len(query) > 0 {
+		reposURL += "?" + query.Encode()
+	}
+	// pages can still overlap when repositories are created or deleted
+	// meanwhile, a repository seen already is left out of later pages
+	seen := map[int]bool{}
+	err := paginate(ctx, api, reposURL, func(page []*MinimalRepository) {
+		unique := make([]*MinimalRepository, 0, len(page))
+		for _, repo := range page {
+			if seen[repo.Id] {
+				slog.Debug("repository listed again, skipping", "repo", repo.Name)
+				continue
+			}
+			seen[repo.Id] = true
+			unique = append(unique, repo)
+		}
+		repos = append(repos, unique...)
+		slog.Info("fetched repositories batch", "repos", len(unique), "total", len(repos))
+		onPage(unique)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// checkOwner requests the org or user behind reposPath, failing with a hint
+// at the likely cause when the token cannot read it.
+func checkOwner(ctx context.Context, api *apiClient, reposPath string, owner string) error {
+	ownerURL := api.baseURL + fmt.Sprintf(strings.TrimSuffix(reposPath, "/repos"), owner)
+	r, err := newAPIRequest(ctx, api, ownerURL)
+	if err != nil {
+		return err
+	}
+
+	resp, err := api.doAPIRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = ssoError(resp)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return errors.Errorf("github rejected the token (401), check that %s is valid and not expired", credentialName(api.tokens))
+	case http.StatusForbidden:
+		return errors.Errorf("token is not allowed to read '%s' (403), check that it has the repo and read:org scopes and is authorized for SSO if the org enforces it", owner)
+	case http.StatusNotFound:
+		return errors.Errorf("'%s' not found (404), check ORG and ACCOUNT_TYPE", owner)
+	default:
+		return responseError(resp, fmt.Sprintf("checking '%s'", owner))
+	}
+}
+
+// paginate requests pageURL and every following page linked from the Link
+// response header, decoding each page as a JSON array of T.
+func paginate[T any](ctx context.Context, api *apiClient, pageURL string, onPage func([]T)) error {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return errors.Wrap(err, "could not parse page url")
+	}
+	q := u.Query()
+	q.Set("per_page", strconv.Itoa(perPage))
+	u.RawQuery = q.Encode()
+	// pages following the first one come from the Link header, which keeps
+	// the query
+	pageURL = u.String()
+
+	for i := 1; ; i++ {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "context finished")
+		default:
+			r, err := newAPIRequest(ctx, api, pageURL)
+			if err != nil {
+				return err
+			}
+			slog.Debug("fetching batch", "url", r.URL.Path, "batch", i)
+
+			resp, err := api.doAPIRequest(ctx, r)
+			if err != nil {
+				return err
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				err := ssoError(resp)
+				if err == nil {
+					err = responseError(resp, fmt.Sprintf("for batch %d", i))
+				}
+				resp.Body.Close()
+				return err
+			}
+
+			respStr := []T{}
+			err = json.NewDecoder(resp.Body).Decode(&respStr)
+			resp.Body.Close()
+			if err != nil {
+				return errors.Wrap(err, "could not decode response")
+			}
+
+			slog.Debug("fetched batch", "url", r.URL.Path, "batch", i, "items", len(respStr))
+			onPage(respStr)
+
+			next, ok := nextPageURL(resp.Header.Get("Link"))
+			if !ok {
+				return nil
+			}
+			pageURL = next
+		}
+	}
+}
+
+// newAPIRequest builds a GET request for rawURL authenticated with a current
+// token, fresh for every page as requests are not reused once sent. It is
+// cancelled with ctx, so a stuck connection does not outlive the timeout.
+func newAPIRequest(ctx context.Context, api *apiClient, rawURL string) (*http.Request, error) {
+	return newAuthenticatedRequest(ctx, api, http.MethodGet, rawURL, nil)
+}
+
+// newAPIJSONRequest builds a request sending payload as JSON, which can be
+// resent after a rate limit as its body is rewindable.
+func newAPIJSONRequest(ctx context.Context, api *apiClient, method, rawURL string, payload any) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal request body")
+	}
+	r, err := newAuthenticatedRequest(ctx, api, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	return r, nil
+}
+
+func newAuthenticatedRequest(ctx context.Context, api *apiClient, method, rawURL string, body io.Reader) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create new http request")
+	}
+	r.Header.Set("Accept", "application/vnd.github+json")
+
+	token, err := api.tokens.Token(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get token")
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r, nil
+}
+
+// doRateLimited performs the request, waiting out GitHub rate limit windows
+// and retrying until a non rate limited response arrives or ctx is done.
+// Network errors and 5xx responses of GET and HEAD requests are retried too,
+// with a backoff starting at serverErrorBackoff, up to serverErrorAttempts
+// attempts. Other requests may have been carried out despite the failure, so
+// they are not repeated.
+func doRateLimited(ctx context.Context, client *http.Client, r *http.Request) (*http.Response, error) {
+	idempotent := r.Method == http.MethodGet || r.Method == http.MethodHead
+	failures := 0
+	for {
+		resp, err := client.Do(r)
+		if err != nil && (ctx.Err() != nil || !idempotent) {
+			return nil, errors.Wrap(err, "could not do the request")
+		}
+		if err == nil && resp.StatusCode >= http.StatusInternalServerError && !idempotent {
+			return resp, nil
+		}
+
+		var wait time.Duration
+		if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+			failures++
+			if failures >= serverErrorAttempts {
+				if err != nil {
+					return nil, errors.Wrapf(err, "could not do the request, %d attempts", failures)
+				}
+				return resp, nil
+			}
+			wait = serverErrorBackoff << (failures - 1)
+			if err != nil {
+				slog.Warn("request to github failed, waiting before retry", "path", r.URL.Path, "wait", wait, "err", err.Error())
+			} else {
+				resp.Body.Close()
+				slog.Warn("github responded with a server error, waiting before retry", "path", r.URL.Path, "status", resp.StatusCode, "wait", wait)
+			}
+		} else {
+			var limited bool
+			wait, limited = rateLimitWait(resp, time.Now())
+			if !limited {
+				return resp, nil
+			}
+			resp.Body.Close()
+			slog.Warn("rate limited by github, waiting before retry", "wait", wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "context finished while waiting to retry the request")
+		case <-time.After(wait):
+		}
+		if r.GetBody != nil {
+			r.Body, err = r.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "could not rewind request body")
+			}
+		}
+	}
+}
+
+// errorBodyLimit is how much of a response body responseError includes.
+const errorBodyLimit = 512
+
+// responseError reports the unexpected status code of resp for what, with the
+// start of the body in which GitHub usually explains it. The body is read, the
+// caller still closes it.
+func responseError(resp *http.Response, what string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit+1))
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if len(body) > errorBodyLimit {
+		text = strings.Join(strings.Fields(string(body[:errorBodyLimit])), " ") + "..."
+	}
+	message := fmt.Sprintf("received invalid response code %s:'%d'", what, resp.StatusCode)
+	if text != "" {
+		message += ", response: " + text
+	}
+	return errors.WithStack(&statusError{code: resp.StatusCode, message: message})
+}
+
+// statusError is an unexpected response status, see responseError.
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// hasStatus reports whether err comes from a response with one of codes.
+func hasStatus(err error, codes ...int) bool {
+	statusErr := &statusError{}
+	return errors.As(err, &statusErr) && slices.Contains(codes, statusErr.code)
+}
+
+// ssoError explains a 403 response refusing a token not authorized for an org
+// enforcing SAML single sign-on, and returns nil for any other response. The
+// body is read, the caller still closes it.
+func ssoError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden {
+		return nil
+	}
+
+	// the read part of the body is put back for responseError
+	read, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(read), resp.Body), resp.Body}
+
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	// the message is only used to recognize and explain the error
+	_ = json.Unmarshal(read, &body)
+
+	sso := resp.Header.Get("X-GitHub-SSO")
+	if sso == "" && !strings.Contains(body.Message, "SAML enforcement") {
+		return nil
+	}
+
+	guidance := "authorize the token for the organization under Settings > Developer settings > Personal access tokens > Configure SSO"
+	if _, authURL, ok := strings.Cut(sso, "url="); ok {
+		guidance = "authorize the token for the organization at " + authURL
+	}
+	return errors.Errorf("token is not authorized for the organization's SAML single sign-on, %s: %s", guidance, body.Message)
+}
+
+// rateLimitWait reports whether resp is a rate limit response and how long to
+// wait before retrying, based on the Retry-After or X-RateLimit-Reset headers.
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		seconds, err := strconv.Atoi(retryAfter)
+		if err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return rateLimitFallbackWait, true
+	}
+	wait := time.Unix(reset, 0).Sub(now) + time.Second
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait, true
+}
+
+// nextPageURL extracts the rel="next" target from a GitHub Link header, e.g.
+// `<https://api.github.com/organizations/1/repos?page=2>; rel="next", <...>; rel="last"`.
+func nextPageURL(linkHeader string) (string, bool) {
+	for _, link := range strings.Split(linkHeader, ",") {
+		segments := strings.Split(link, ";")
+		if len(segments) < 2 {
+			continue
+		}
+
+		target := strings.TrimSpace(segments[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range segments[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(target, "<>"), true
+			}
+		}
+	}
+	return "", false
+}
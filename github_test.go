@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pagesServer serves pages of repositories, linking each page to the
+// following one. With hang the last page links to one more, which never
+// arrives.
+func pagesServer(t *testing.T, pages [][]*MinimalRepository, hang bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page > len(pages) {
+			<-r.Context().Done()
+			return
+		}
+		if page < len(pages) || hang {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?per_page=%d&page=%d>; rel="next"`, r.Host, r.URL.Path, perPage, page+1))
+		}
+		err := json.NewEncoder(w).Encode(pages[page-1])
+		if err != nil {
+			t.Errorf("could not encode page %d: %v", page, err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPaginateCancelled(t *testing.T) {
+	srv := pagesServer(t, [][]*MinimalRepository{{testRepo(1, "one")}}, true)
+	api := newAPIClient(srv.URL, srv.Client(), staticToken("token"), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	pages := 0
+	err := paginate(ctx, api, srv.URL+"/orgs/org/repos", func([]*MinimalRepository) { pages++ })
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "deadline") {
+		t.Fatalf("error %v, want one mentioning the deadline", err)
+	}
+	if pages != 1 {
+		t.Errorf("%d pages handed over before the deadline, want 1", pages)
+	}
+}
+
+func TestFetchReposDataOverlappingPages(t *testing.T) {
+	srv := pagesServer(t, [][]*MinimalRepository{
+		{testRepo(1, "one"), testRepo(2, "two")},
+		// a repository created meanwhile shifted two onto this page
+		{testRepo(2, "two"), testRepo(3, "three")},
+		{testRepo(3, "three"), testRepo(4, "four")},
+	}, false)
+	api := newAPIClient(srv.URL, srv.Client(), staticToken("token"), 1)
+
+	handed := []*MinimalRepository{}
+	repos, err := fetchReposData(context.Background(), api, "/orgs/%s/repos", "org", nil, func(page []*MinimalRepository) {
+		handed = append(handed, page...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{1, 2, 3, 4}
+	for name, got := range map[string][]*MinimalRepository{"returned": repos, "handed to onPage": handed} {
+		ids := []int{}
+		for _, repo := range got {
+			ids = append(ids, repo.Id)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("repository ids %s %v, want %v", name, ids, want)
+		}
+	}
+}
+
+func TestCheckOwnerUnauthorizedNamesCredential(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+
+	for tokens, want := range map[tokenSource]string{
+		staticToken("token"): "GITHUB_TOKEN ",
+		fileToken("token"):   "GITHUB_TOKEN_FILE",
+	} {
+		api := newAPIClient(srv.URL, srv.Client(), tokens, 1)
+		err := checkOwner(context.Background(), api, orgReposPath, "org")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v, want one naming %s", err, want)
+		}
+	}
+}
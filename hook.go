@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// logsDir holds the output of POST_CLONE_HOOK and, with PER_REPO_LOGS, the
+// log records of the repository, one file per repository.
+const logsDir = "logs"
+
+// runPostCloneHook runs hook with the absolute path of the clone of repo as
+// last argument and inside it, writing its output to logs/<repo>.log. The exit
+// code is recorded in result, a failing hook does not fail the repository.
+func runPostCloneHook(ctx context.Context, hook []string, dirFilename string, repo *MinimalRepository, result *RepoArchiveResult) {
+	code, err := execHook(ctx, hook, dirFilename, filepath.Join(dirFilename, result.Directory), repo.Name)
+	result.HookExitCode = &code
+	if err != nil {
+		slog.Error("post clone hook failed", "repo", repo.Name, "exit_code", code, "err", err.Error())
+		return
+	}
+	slog.Debug("post clone hook finished", "repo", repo.Name)
+}
+
+func execHook(ctx context.Context, hook []string, dirFilename, repoDir, name string) (int, error) {
+	repoDir, err := filepath.Abs(repoDir)
+	if err != nil {
+		return -1, err
+	}
+	logPath := filepath.Join(dirFilename, logsDir, name+".log")
+	err = os.MkdirAll(filepath.Dir(logPath), dirMode)
+	if err != nil {
+		return -1, errors.Wrap(err, "could not create hook logs directory")
+	}
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return -1, errors.Wrap(err, "could not create hook log")
+	}
+	defer log.Close()
+
+	cmd := exec.CommandContext(ctx, hook[0], append(hook[1:], repoDir)...)
+	cmd.Dir = repoDir
+	cmd.Stdout = log
+	cmd.Stderr = log
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), errors.Errorf("exited with code %d, see %s", exitErr.ExitCode(), logPath)
+	}
+	if err != nil {
+		return -1, errors.Wrap(err, "could not run hook")
+	}
+	return 0, nil
+}
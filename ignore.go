@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/pkg/errors"
+)
+
+// archiveIgnoreFile holds gitignore style patterns of paths left out of the
+// archive, read from the current directory.
+const archiveIgnoreFile = ".archiveignore"
+
+// loadArchiveIgnore parses the patterns in path, returning nil when the file
+// does not exist.
+func loadArchiveIgnore(path string) (gitignore.Matcher, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open ignore file")
+	}
+	defer f.Close()
+
+	patterns := []gitignore.Pattern{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read ignore file")
+	}
+	return gitignore.NewMatcher(patterns), nil
+}
+
+// ignored reports whether the entry at rel, relative to the archive root,
+// matches ignore relative to the root of the repository holding it. Files
+// outside of repositories and API metadata are never ignored.
+func ignored(ignore gitignore.Matcher, rel string, isDir bool) bool {
+	if ignore == nil {
+		return false
+	}
+
+	root := contentRoot(rel)
+	if root == rel || strings.HasPrefix(root, metadataDir+"/") {
+		return false
+	}
+	return ignore.Match(strings.Split(strings.TrimPrefix(rel, root+"/"), "/"), isDir)
+}
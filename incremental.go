@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// previousArchive is an extracted archive, or a working directory kept with
+// NO_ZIP, whose unchanged clones are reused by incremental runs.
+type previousArchive struct {
+	dir   string
+	repos map[string]RepoArchiveResult
+}
+
+func loadPreviousArchive(dir string) (*previousArchive, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read previous manifest")
+	}
+
+	m := Manifest{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode previous manifest")
+	}
+
+	repos := map[string]RepoArchiveResult{}
+	for _, repo := range m.Repositories {
+		repos[repo.Name] = repo
+	}
+	return &previousArchive{dir: dir, repos: repos}, nil
+}
+
+// reuse copies the previous clone of repo into repoDir when it was cloned
+// into the same directory with the same options, and nothing was pushed to
+// the repository since.
+func (p *previousArchive) reuse(repo *MinimalRepository, directory, repoDir string, options CloneOptions) (RepoArchiveResult, bool, error) {
+	previous, ok := p.repos[repo.Name]
+	pushedAt, _ := repo.PushedAt.(string)
+	if !ok || previous.Status != statusCloned || previous.Directory != directory ||
+		previous.CloneOptions == nil || *previous.CloneOptions != options ||
+		pushedAt == "" || previous.PushedAt != pushedAt {
+		return RepoArchiveResult{}, false, nil
+	}
+
+	err := copyTree(filepath.Join(p.dir, previous.Directory), repoDir)
+	if err != nil {
+		// leave no partial copy behind so that a fresh clone can take its place
+		os.RemoveAll(repoDir)
+		return RepoArchiveResult{}, false, err
+	}
+
+	// only what comes from the clone carries over, metadata, the wiki and the
+	// hook are the business of this run
+	return RepoArchiveResult{
+		Name:              previous.Name,
+		Directory:         previous.Directory,
+		Status:            previous.Status,
+		Duration:          previous.Duration,
+		SizeBytes:         previous.SizeBytes,
+		HeadSHA:           previous.HeadSHA,
+		HeadCommitTime:    previous.HeadCommitTime,
+		PushedAt:          previous.PushedAt,
+		Reused:            true,
+		LFS:               previous.LFS,
+		Submodules:        previous.Submodules,
+		ReportedSizeBytes: previous.ReportedSizeBytes,
+		ObjectsSizeBytes:  previous.ObjectsSizeBytes,
+		GitStripped:       previous.GitStripped,
+		CloneOptions:      previous.CloneOptions,
+	}, true, nil
+}
+
+// copyTree copies src into dst, hard linking files where possible as the
+// previous archive is not modified.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			if os.Link(path, target) == nil {
+				return nil
+			}
+			return copyRegularFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyRegularFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
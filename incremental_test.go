@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreviousArchiveReuseResetsRunFields(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "previous", "one"), dirMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "previous", "one", "README"), []byte("one"), fileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode := 1
+	options := CloneOptions{Depth: 1}
+	previous := &previousArchive{dir: filepath.Join(dir, "previous"), repos: map[string]RepoArchiveResult{
+		"one": {
+			Name: "one", Directory: "one", Status: statusCloned, PushedAt: "2024-01-01T00:00:00Z",
+			HeadSHA: "abc", SizeBytes: 3, CloneOptions: &options,
+			Releases: 1, Issues: 2, Pulls: 3, Collaborators: 4, Webhooks: 5, ProtectedBranches: 6,
+			HookExitCode: &exitCode, Wiki: wikiCloned, MetadataErrors: []string{"issues"},
+		},
+	}}
+	repo := testRepo(1, "one")
+	repo.PushedAt = "2024-01-01T00:00:00Z"
+
+	result, ok, err := previous.reuse(repo, "one", filepath.Join(dir, "current", "one"), options)
+	if err != nil || !ok {
+		t.Fatalf("reused %t, %v", ok, err)
+	}
+	if !result.Reused || result.HeadSHA != "abc" || result.SizeBytes != 3 || result.CloneOptions == nil {
+		t.Errorf("clone data not carried over: %+v", result)
+	}
+	if result.Releases != 0 || result.Issues != 0 || result.Pulls != 0 || result.Collaborators != 0 ||
+		result.Webhooks != 0 || result.ProtectedBranches != 0 || result.HookExitCode != nil ||
+		result.Wiki != "" || result.MetadataErrors != nil {
+		t.Errorf("fields of the previous run carried over: %+v", result)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveInfoFile describes, at the root of the archive, how it was made.
+const archiveInfoFile = "ARCHIVE_INFO.txt"
+
+// storeArchiveInfo writes the owner, creation time, effective options and
+// counts of the run to archiveInfoFile. Credentials are left out.
+func storeArchiveInfo(cfg runConfig, summary RunSummary, created time.Time, dirFilename string) error {
+	filters := []string{}
+	for _, filter := range cfg.filters {
+		filters = append(filters, filter.name)
+	}
+	mtimeMode := cfg.mtimeMode
+	if mtimeMode == "" {
+		mtimeMode = mtimeCheckout
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Archive of the GitHub repositories of %s\n\n", cfg.org)
+	for _, line := range [][2]string{
+		{"owner", cfg.org},
+		{"created", created.UTC().Format(time.RFC3339)},
+		{"version", summary.Version},
+		{"api", cfg.api.baseURL},
+		{"repos path", fmt.Sprintf(cfg.reposPath, cfg.org)},
+		{"repos query", cfg.reposQuery.Encode()},
+		{"filters", strings.Join(filters, ", ")},
+		{"max repos", fmt.Sprint(cfg.maxRepos)},
+		{"schedule", cfg.schedule},
+		{"clone depth", fmt.Sprint(cfg.clone.depth)},
+		{"mirror", fmt.Sprint(cfg.clone.mirror)},
+		{"all branches", fmt.Sprint(cfg.clone.allBranches)},
+		{"single branch", fmt.Sprint(cfg.clone.singleBranch)},
+		{"strip git", fmt.Sprint(cfg.clone.stripGit)},
+		{"clone ref", cfg.clone.ref},
+		{"clone timeout", cfg.clone.timeout.String()},
+		{"lfs", fmt.Sprint(cfg.clone.fetchLFS)},
+		{"submodules", fmt.Sprint(cfg.clone.submodules)},
+		{"ssh", fmt.Sprint(cfg.clone.sshAuth != nil)},
+		{"tls verification", fmt.Sprint(!cfg.clone.insecureSkipTLSVerify)},
+		{"incremental", fmt.Sprint(cfg.clone.previous != nil)},
+		{"releases", fmt.Sprint(cfg.clone.fetchReleases)},
+		{"issues", fmt.Sprint(cfg.clone.fetchIssues)},
+		{"pulls", fmt.Sprint(cfg.clone.fetchPulls)},
+		{"wiki", fmt.Sprint(cfg.clone.fetchWiki)},
+		{"collaborators", fmt.Sprint(cfg.clone.fetchCollaborators)},
+		{"webhooks", fmt.Sprint(cfg.clone.fetchWebhooks)},
+		{"branch protection", fmt.Sprint(cfg.clone.fetchBranchProtection)},
+		{"post clone hook", strings.Join(cfg.clone.postCloneHook, " ")},
+		{"per repo logs", fmt.Sprint(cfg.perRepoLogs)},
+		{"archive format", cfg.archiveFormat},
+		{"compression level", fmt.Sprint(cfg.compressionLevel)},
+		{"mtime", mtimeMode},
+		{"max archive size", fmt.Sprint(cfg.maxArchiveSize)},
+		{"encrypted", fmt.Sprint(cfg.recipient != nil)},
+		{"repos fetched", fmt.Sprint(summary.ReposFetched)},
+		{"repos cloned", fmt.Sprint(summary.ReposCloned)},
+		{"repos skipped", fmt.Sprint(summary.ReposSkipped)},
+		{"repos failed", fmt.Sprint(summary.ReposFailed)},
+		{"total bytes", fmt.Sprint(summary.TotalBytes)},
+		{"fetch duration", summary.FetchDuration.String()},
+		{"clone duration", summary.CloneDuration.String()},
+	} {
+		fmt.Fprintln(buf, strings.TrimSpace(fmt.Sprintf("%-18s %s", line[0]+":", line[1])))
+	}
+	fmt.Fprintf(buf, "\nmanifest.json lists the archived repositories, summary.json the counts above.\n")
+	return os.WriteFile(filepath.Join(dirFilename, archiveInfoFile), buf.Bytes(), fileMode)
+}
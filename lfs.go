@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+const (
+	lfsFetched     = "fetched"
+	lfsFailed      = "failed"
+	lfsUnavailable = "git-lfs not installed"
+)
+
+// usesLFS reports whether .gitattributes at HEAD routes any path through the
+// LFS filter.
+func usesLFS(r *git.Repository) (bool, error) {
+	contents, found, err := headFile(r, ".gitattributes")
+	if err != nil || !found {
+		return false, err
+	}
+	return strings.Contains(contents, "filter=lfs"), nil
+}
+
+// headFile reads a file committed at HEAD, so it works for bare clones too.
+func headFile(r *git.Repository, name string) (string, bool, error) {
+	head, err := r.Head()
+	if err != nil {
+		return "", false, err
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return "", false, err
+	}
+
+	file, err := commit.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return "", false, err
+	}
+	return contents, true, nil
+}
+
+func lfsInstalled() bool {
+	_, err := exec.LookPath("git-lfs")
+	return err == nil
+}
+
+// fetchLFS downloads all LFS objects of the clone of cloneURL in repoDir and,
+// unless it is bare, replaces the pointer files in its working tree with
+// their content.
+func fetchLFS(ctx context.Context, repoDir, cloneURL string, cfg cloneConfig) error {
+	err := runGit(ctx, repoDir, cloneURL, cfg, "lfs", "fetch", "--all")
+	if err != nil || cfg.mirror {
+		return err
+	}
+	return runGit(ctx, repoDir, cloneURL, cfg, "lfs", "checkout")
+}
+
+// runGit runs the git binary in repoDir, authenticating the same way as the
+// clone of cloneURL did. Configuration is passed in the environment rather
+// than on the command line, which other users can read, and the token is only
+// sent to the host of cloneURL, not to storage hosts LFS may redirect to.
+func runGit(ctx context.Context, repoDir, cloneURL string, cfg cloneConfig, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = os.Environ()
+	gitConfig := [][2]string{}
+	if cfg.sshAuth != nil {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -i "+shellQuote(cfg.sshKeyPath)+" -o IdentitiesOnly=yes")
+	} else {
+		u, err := url.Parse(cloneURL)
+		if err != nil {
+			return errors.Wrap(err, "invalid clone URL")
+		}
+		token, err := cfg.tokens.Token(ctx)
+		if err != nil {
+			return errors.Wrap(err, "could not get token")
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte("username:" + token))
+		gitConfig = append(gitConfig, [2]string{
+			"http." + u.Scheme + "://" + u.Host + "/.extraHeader",
+			"Authorization: Basic " + credentials,
+		})
+	}
+	if cfg.caCertFile != "" {
+		gitConfig = append(gitConfig, [2]string{"http.sslCAInfo", cfg.caCertFile})
+	}
+	if cfg.insecureSkipTLSVerify {
+		gitConfig = append(gitConfig, [2]string{"http.sslVerify", "false"})
+	}
+	cmd.Env = append(cmd.Env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(gitConfig)))
+	for i, entry := range gitConfig {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, entry[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, entry[1]))
+	}
+	cmd.Dir = repoDir
+
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "git %s failed: %s", strings.Join(args, " "), strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single word for sh, which runs GIT_SSH_COMMAND.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
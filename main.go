@@ -1,260 +1,613 @@
 package main
 
 import (
-	"archive/zip"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
 	"os"
-	"path"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/go-git/go-git/v5"
-	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/pkg/errors"
 )
 
 const (
-	fileDateLayout = "2006-01-02_15:04:05"
+	fileDateLayout = "2006-01-02_15-04-05"
 	cloningWorkers = 5
-	maxPages       = 10
+	apiConcurrency = 4
 	perPage        = 100
-	reposURL       = "https://api.github.com/orgs/%s/repos"
+	defaultAPIURL  = "https://api.github.com"
+	orgReposPath   = "/orgs/%s/repos"
+	userReposPath  = "/users/%s/repos"
 	programTimeout = 30 * time.Minute
+
+	rateLimitFallbackWait = time.Minute
+	serverErrorAttempts   = 4
+	serverErrorBackoff    = time.Second
+
+	// slowestClonesLogged is how many of the slowest clones are logged after
+	// cloning.
+	slowestClonesLogged = 5
+
+	mtimeCheckout = "checkout"
+	mtimeCommit   = "commit"
+
+	// scheduleListing clones repositories in the order they are listed,
+	// scheduleSize the largest ones first.
+	scheduleListing = "listing"
+	scheduleSize    = "size"
+
+	// stdoutOutput as OUTPUT streams the archive to stdout.
+	stdoutOutput = "-"
+
+	// dirMode and fileMode apply to everything written by the program, which
+	// may hold private code, before the umask.
+	dirMode  = 0o755
+	fileMode = 0o644
 )
 
+// errInterrupted is returned by run after archiving what was cloned before a
+// signal arrived.
+var errInterrupted = errors.New("interrupted")
+
+// summaryLog logs the results of a run, which are kept with QUIET.
+var summaryLog = slog.Default()
+
+// failureLog lists failed repositories on stderr with PER_REPO_LOGS, apart
+// from the rest of the output. It is kept with QUIET too.
+var failureLog = slog.Default()
+
 func main() {
-	org := os.Getenv("ORG")
-	if org == "" {
-		panic("ORG env expected")
+	configPath := flag.String("config", "", "YAML or JSON file with options, overridden by environment variables")
+	force := flag.Bool("force", false, "overwrite the output of a previous run named with OUTPUT_NAME")
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *printVersion {
+		fmt.Println("archive-github-org", toolVersion())
+		return
 	}
-
-	githubToken := os.Getenv("GITHUB_TOKEN")
-	if githubToken == "" {
-		panic("GITHUB_TOKEN env expected")
+	if *configPath != "" {
+		options, err := loadConfigFile(*configPath)
+		if err != nil {
+			slog.Error("invalid configuration", "err", err.Error())
+			os.Exit(1)
+		}
+		fileOptions = options
 	}
 
-	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), programTimeout)
-	defer cancel()
-
-	reposData, err := fetchReposData(ctx, org, githubToken)
+	err := setupLogging()
 	if err != nil {
-		panic("could not fetch repos data:" + err.Error())
+		slog.Error("invalid configuration", "err", err.Error())
+		os.Exit(1)
 	}
-	fmt.Printf("Data for %d repositories fetched in total\n", len(reposData))
 
-	dirFilename := fmt.Sprintf("%s-archive-%s", org, time.Now().Format(fileDateLayout))
-	err = os.Mkdir(dirFilename, os.ModePerm)
+	cfg, err := configFromEnv()
 	if err != nil {
-		panic("could not create directory:" + err.Error())
+		slog.Error("invalid configuration", "err", err.Error())
+		os.Exit(1)
 	}
+	cfg.force = *force
 
-	wg := &sync.WaitGroup{}
-	storeReposResponses(wg, reposData, dirFilename)
-	cloneRepos(ctx, wg, dirFilename, githubToken, reposData)
-
-	fmt.Println("Waiting for workers to finish...")
-	wg.Wait()
+	slog.Info("starting", "version", toolVersion())
+	slog.Info("program timeout set", "timeout", cfg.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
 
-	fmt.Println("Preparing zip archive...")
-	zipFile, err := os.OpenFile(dirFilename+".zip", os.O_CREATE|os.O_WRONLY, os.ModePerm)
-	if err != nil {
-		panic("could not open zip file:" + err.Error())
+	if cfg.metricsAddr != "" {
+		go serveMetrics(ctx, cfg.metricsAddr)
 	}
-	defer zipFile.Close()
 
-	w := zip.NewWriter(zipFile)
-	defer w.Close()
-	err = fillZipWriter(dirFilename, w)
-	if err != nil {
-		panic("could not fill zip writer:" + err.Error())
+	commands := map[string]func(context.Context, runConfig, string) error{
+		verifyCommand:  verify,
+		restoreCommand: restore,
+	}
+	if command, ok := commands[flag.Arg(0)]; ok {
+		if flag.NArg() != 2 || len(cfg.orgs) > 1 {
+			slog.Error(flag.Arg(0) + " expects the archive path as its only argument and a single ORG")
+			os.Exit(2)
+		}
+		err = command(ctx, cfg, flag.Arg(1))
+		if err != nil {
+			cancel()
+			slog.Error(flag.Arg(0)+" failed", "err", err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	err = os.RemoveAll(dirFilename)
-	if err != nil {
-		panic("could not remove working directory:" + err.Error())
+	if len(cfg.orgs) == 1 {
+		err = run(ctx, cfg)
+		if err != nil {
+			cancel()
+			slog.Error("archiving failed", "err", err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	fmt.Printf("Done in %s!\n", time.Since(start))
+	if !runOrgs(ctx, cfg) {
+		cancel()
+		os.Exit(1)
+	}
 }
 
-func fillZipWriter(dirFilename string, w *zip.Writer) error {
-	return filepath.WalkDir(dirFilename, func(path string, entry os.DirEntry, err error) error {
+// runOrgs archives every organisation of cfg.orgs into its own archive, one
+// after another, and logs how each of them went. It reports whether all of
+// them succeeded.
+func runOrgs(ctx context.Context, cfg runConfig) bool {
+	results := map[string]error{}
+	for _, org := range cfg.orgs {
+		orgCfg := cfg
+		orgCfg.org = org
+		slog.Info("archiving organisation", "org", org)
+		err := run(ctx, orgCfg)
+		results[org] = err
 		if err != nil {
-			return err
+			slog.Error("archiving failed", "org", org, "err", err.Error())
+		}
+		if errors.Is(err, errInterrupted) || ctx.Err() != nil {
+			break
 		}
+	}
 
-		if entry.IsDir() {
-			return nil
+	ok := true
+	for _, org := range cfg.orgs {
+		err, done := results[org]
+		switch {
+		case !done:
+			ok = false
+			summaryLog.Error("organisation skipped", "org", org)
+		case err != nil:
+			ok = false
+			summaryLog.Error("organisation failed", "org", org, "err", err.Error())
+		default:
+			summaryLog.Info("organisation archived", "org", org)
 		}
+	}
+	return ok
+}
 
-		file, err := entry.Info()
+// setupLogging configures the default logger and summaryLog with LOG_LEVEL,
+// LOG_FORMAT and QUIET.
+func setupLogging() error {
+	logLevel := slog.LevelInfo
+	if v := getenv("LOG_LEVEL"); v != "" {
+		err := logLevel.UnmarshalText([]byte(v))
 		if err != nil {
-			return err
+			return errors.Errorf("LOG_LEVEL env expected to be one of debug, info, warn or error, got '%s'", v)
 		}
-		if file.Mode()&os.ModeSymlink == os.ModeSymlink {
-			linkTarget, err := os.Readlink(path)
-			if err != nil {
-				return err
-			}
-
-			header := &zip.FileHeader{
-				Name:   path,
-				Method: zip.Store,
-			}
-			header.SetMode(os.ModeSymlink)
+	}
+	logOutput := os.Stdout
+	if getenv("OUTPUT") == stdoutOutput {
+		// stdout carries the archive
+		logOutput = os.Stderr
+	}
+	summaryLevel := min(logLevel, slog.LevelInfo)
+	quiet, err := boolEnv("QUIET", false)
+	if err != nil {
+		return err
+	}
+	if quiet {
+		// only warnings, errors and the summary lines
+		logLevel = max(logLevel, slog.LevelWarn)
+	}
+	newHandler := func(w io.Writer, level slog.Level) slog.Handler {
+		return slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+	}
+	switch format := getenv("LOG_FORMAT"); format {
+	case "", "text":
+	case "json":
+		newHandler = func(w io.Writer, level slog.Level) slog.Handler {
+			return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+		}
+	default:
+		return errors.Errorf("LOG_FORMAT env expected to be text or json, got '%s'", format)
+	}
+	slog.SetDefault(slog.New(newHandler(logOutput, logLevel)))
+	summaryLog = slog.New(newHandler(logOutput, summaryLevel))
+	failureLog = slog.New(newHandler(os.Stderr, summaryLevel))
+	return nil
+}
 
-			writer, err := w.CreateHeader(header)
-			if err != nil {
-				return err
-			}
+// run archives the repositories described by cfg. The working directory is
+// removed when it fails, unless noZip asks to keep it.
+func run(ctx context.Context, cfg runConfig) (err error) {
+	start := time.Now()
 
-			_, err = writer.Write([]byte(linkTarget))
-			if err != nil {
-				return err
-			}
-			return nil
+	// interrupt stops fetching and queueing repositories on SIGINT or SIGTERM
+	// while in-flight clones finish with ctx, so that a partial archive is
+	// still produced. Signals are reset afterwards, a second one kills the
+	// program.
+	interrupt, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	// returned is closed before signals are stopped, which also cancels
+	// interrupt, so that returning is not taken for a signal
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		<-interrupt.Done()
+		select {
+		case <-returned:
+			return
+		default:
+		}
+		stopSignals()
+		if ctx.Err() == nil {
+			slog.Warn("interrupted, finishing in-flight clones, interrupt again to force exit")
 		}
+	}()
+
+	selector := newRepoSelector(cfg.filters, cfg.maxRepos)
 
-		header, err := zip.FileInfoHeader(file)
+	if cfg.fromManifest == "" {
+		err = checkOwner(interrupt, cfg.api, cfg.reposPath, cfg.org)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "could not access repositories owner")
 		}
-		header.Name, err = filepath.Rel(dirFilename, path)
+	}
+
+	if cfg.dryRun {
+		reposData, err := listRepos(interrupt, cfg, func([]*MinimalRepository) {})
 		if err != nil {
-			return err
+			return errors.Wrap(err, "could not fetch repos data")
 		}
+		slog.Info("repositories data fetched", "count", len(reposData))
 
-		writer, err := w.CreateHeader(header)
+		selected := selector.selectRepos(reposData)
+		selector.printSummary()
+		err = checkRepoDirectories(cfg.clone, selected)
 		if err != nil {
 			return err
 		}
+		printDryRun(selected)
+		return nil
+	}
 
-		fileReader, err := os.Open(path)
+	dirFilename := fmt.Sprintf("%s-archive-%s", cfg.org, time.Now().Format(fileDateLayout))
+	if cfg.outputName != "" {
+		dirFilename = cfg.outputName
+		err = clearOutputs(dirFilename, cfg)
 		if err != nil {
 			return err
 		}
-		defer fileReader.Close()
+	}
+	err = os.Mkdir(dirFilename, dirMode)
+	if err != nil {
+		return errors.Wrap(err, "could not create directory")
+	}
+	removed := false
+	defer func() {
+		if err == nil || cfg.noZip || removed {
+			return
+		}
+		slog.Info("removing working directory after failure", "dir", dirFilename)
+		rmErr := os.RemoveAll(dirFilename)
+		if rmErr != nil {
+			slog.Error("could not remove working directory", "dir", dirFilename, "err", rmErr)
+		}
+	}()
 
-		_, err = io.Copy(writer, fileReader)
-		return err
-	})
-}
+	budget := newDiskBudget(dirFilename, cfg.diskSpaceMultiplier)
 
-func cloneRepos(ctx context.Context, wg *sync.WaitGroup, dirFilename string, githubToken string, reposData []*MinimalRepository) {
-	work := make(chan string)
-
-	for i := range cloningWorkers {
-		wg.Add(1)
-		i := i
-		go func() {
-			defer wg.Done()
-			fmt.Printf("starting worker %d\n", i)
-			for {
-				select {
-				case <-ctx.Done():
-					fmt.Printf("context done for worker %d, %s\n", i, ctx.Err().Error())
-					return
-				case s, ok := <-work:
-					if !ok {
-						fmt.Printf("work done for worker %d\n", i)
-						return
-					}
-
-					path := path.Base(s)
-					path = strings.TrimSuffix(path, ".git")
-					_, err := git.PlainCloneContext(ctx, dirFilename+"/"+path, false, &git.CloneOptions{
-						URL: s,
-						Auth: &githttp.BasicAuth{
-							Username: "username",
-							Password: githubToken,
-						},
-					})
-					if err != nil {
-						fmt.Printf("\nerror cloning %s:%s\n", s, err.Error())
-					}
-				}
-			}
-		}()
+	if cfg.perRepoLogs {
+		previous := slog.Default()
+		defer slog.SetDefault(previous)
+		cfg.clone.logs = newRepoLogs(dirFilename)
+		slog.SetDefault(slog.New(&repoLogHandler{inner: previous.Handler(), logs: cfg.clone.logs}))
 	}
 
-	for i, repo := range reposData {
-		work <- repo.CloneUrl
-		fmt.Printf("cloning of '%s' requested, %d/%d\n", repo.Name, i+1, len(reposData))
+	wg := &sync.WaitGroup{}
+	cloneStart := time.Now()
+	progress := &cloneProgress{}
+	// stop is cancelled as well when archiving has to be aborted, so that
+	// workers do not start any more clones before the working directory is
+	// removed.
+	stop, abort := context.WithCancel(interrupt)
+	defer abort()
+	work, manifest := cloneRepos(ctx, stop, wg, cfg.clone, dirFilename, progress)
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	if cfg.showProgress {
+		go progress.report(progressCtx, progressInterval)
 	}
-	close(work)
-}
 
-func storeReposResponses(wg *sync.WaitGroup, reposData []*MinimalRepository, dirFilename string) {
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		fmt.Println("saving fetched repositories responses to file...")
-		j, err := json.MarshalIndent(reposData, "", "  ")
-		if err != nil {
-			panic("could not marshal repos:" + err.Error())
+	fetchStart := time.Now()
+	reposData := []*MinimalRepository{}
+	var budgetErr, dirErr error
+	// queue hands repo to the workers, reporting whether to go on
+	queue := func(repo *MinimalRepository) bool {
+		if stop.Err() != nil {
+			return false
 		}
-		err = os.WriteFile(dirFilename+"/responses.json", j, os.ModePerm)
-		if err != nil {
-			panic("could not write to file:" + err.Error())
+		dirErr = checkRepoDirectories(cfg.clone, []*MinimalRepository{repo})
+		if dirErr != nil {
+			abort()
+			return false
+		}
+		budgetErr = budget.reserve(repo)
+		if budgetErr != nil {
+			abort()
+			return false
 		}
-		fmt.Println("fetched repositories responses saved to file")
-	}()
-}
 
-func fetchReposData(ctx context.Context, org string, githubToken string) ([]*MinimalRepository, error) {
-	url := fmt.Sprintf(reposURL, org)
-	r, err := http.NewRequest(http.MethodGet, url, nil)
+		reposData = append(reposData, repo)
+		if cfg.listSelection {
+			slog.Info("repository selected", "repo", repo.Name)
+		}
+		select {
+		case work <- repo:
+			progress.requested.Add(1)
+			slog.Debug("cloning requested", "repo", repo.Name, "position", len(reposData))
+			return true
+		case <-stop.Done():
+			return false
+		}
+	}
+	// with scheduleSize repositories are only queued once all are known
+	pending := []*MinimalRepository{}
+	fetched, err := listRepos(stop, cfg, func(page []*MinimalRepository) {
+		reposFetchedMetric.Add(float64(len(page)))
+		for _, repo := range selector.selectRepos(page) {
+			if cfg.schedule == scheduleSize {
+				pending = append(pending, repo)
+			} else if !queue(repo) {
+				return
+			}
+		}
+	})
+	fetchDuration := time.Since(fetchStart)
+	if err == nil && len(pending) > 0 {
+		// the largest first, so that none of them is left to start last
+		// while other workers idle
+		sort.SliceStable(pending, func(i, j int) bool {
+			return pending[i].Size > pending[j].Size
+		})
+		for _, repo := range pending {
+			if !queue(repo) {
+				break
+			}
+		}
+	}
+	close(work)
+	interrupted := interrupt.Err() != nil && ctx.Err() == nil
+	switch {
+	case dirErr != nil:
+		err = dirErr
+	case budgetErr != nil:
+		err = budgetErr
+	case interrupted:
+		err = nil
+	case err != nil:
+		err = errors.Wrap(err, "could not fetch repos data")
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "could not create new http request")
+		abort()
+		stopProgress()
+		wg.Wait()
+		return err
 	}
+	slog.Info("repositories data fetched", "count", len(fetched), "duration", fetchDuration)
+	selector.printSummary()
+	slog.Info("repositories left to archive", "count", len(reposData))
 
-	r.Header.Set("Accept", "application/vnd.github+json")
-	r.Header.Set("Authorization", "Bearer "+githubToken)
+	err = storeReposResponses(reposData, dirFilename)
+	if err != nil {
+		abort()
+		stopProgress()
+		wg.Wait()
+		return err
+	}
 
-	repos := []*MinimalRepository{}
+	slog.Info("waiting for workers to finish")
+	wg.Wait()
+	stopProgress()
+	interrupted = interrupt.Err() != nil && ctx.Err() == nil
 
-	client := &http.Client{}
-	for i := 1; i <= maxPages; i++ {
-		select {
-		case <-ctx.Done():
-			return nil, errors.Wrap(err, "context finished")
-		default:
-			q := r.URL.Query()
-			q.Set("per_page", strconv.Itoa(perPage))
-			q.Set("page", strconv.Itoa(i))
-			r.URL.RawQuery = q.Encode()
+	cloneDuration := time.Since(cloneStart)
 
-			fmt.Printf("fetching %d. batch\n", i)
-			resp, err := client.Do(r)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not do the request")
-			}
+	manifest.Version = toolVersion()
+	err = storeManifest(manifest, dirFilename)
+	if err != nil {
+		return errors.Wrap(err, "could not store manifest")
+	}
+
+	for _, repo := range manifest.slowest(slowestClonesLogged) {
+		summaryLog.Info("slowest clone", "repo", repo.Name, "duration", repo.Duration, "size_bytes", repo.SizeBytes, "status", repo.Status)
+	}
 
-			if resp.StatusCode != http.StatusOK {
-				return nil, errors.Errorf("received invalid response code for batch %d:'%d'", i, resp.StatusCode)
+	if cfg.perRepoLogs {
+		// one line per failure for a quick look, the details are in the logs
+		for _, repo := range manifest.Repositories {
+			if repo.Status == statusFailed {
+				failureLog.Error("repository failed", "repo", repo.Name, "err", repo.Error, "log", filepath.Join(logsDir, repo.Name+".log"))
 			}
+		}
+	}
 
-			respStr := []*MinimalRepository{}
-			err = json.NewDecoder(resp.Body).Decode(&respStr)
+	summary := newRunSummary(len(fetched), selector.skippedTotal(), manifest, fetchDuration, cloneDuration)
+	if cfg.reportDuplicates {
+		duplicates, err := findDuplicateObjects(dirFilename, manifest)
+		if err != nil {
+			slog.Warn("could not look for objects duplicated across repositories", "err", err)
+		} else {
+			summaryLog.Info("objects duplicated across repositories", "objects", duplicates.Objects, "bytes", duplicates.Bytes)
+			summary.DuplicateObjects = &duplicates
+		}
+	}
+	err = storeRunSummary(summary, dirFilename)
+	if err != nil {
+		return errors.Wrap(err, "could not store run summary")
+	}
+	err = storeArchiveInfo(cfg, summary, start, dirFilename)
+	if err != nil {
+		return errors.Wrap(err, "could not store archive info")
+	}
+
+	output := dirFilename
+	if cfg.noZip {
+		slog.Info("skipping archive creation, repositories are left in the working directory")
+	} else {
+		slog.Info("preparing archive", "format", cfg.archiveFormat)
+		opts := archiveOptions{
+			format:    cfg.archiveFormat,
+			level:     cfg.compressionLevel,
+			workers:   cfg.compressionWorkers,
+			recipient: cfg.recipient,
+			modTimes:  map[string]time.Time{},
+			ignore:    cfg.ignore,
+		}
+		if cfg.mtimeMode == mtimeCommit {
+			opts.modTimes = manifest.commitTimes()
+		}
+		files := []string{dirFilename + archiveExtension(cfg.archiveFormat, cfg.recipient)}
+		if cfg.toStdout {
+			err = writeArchiveTo(dirFilename, os.Stdout, opts)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not decode response")
+				return errors.Wrap(err, "could not write archive to stdout")
 			}
-			resp.Body.Close()
+			files = []string{"stdout"}
+		} else if cfg.maxArchiveSize > 0 {
+			files, err = writeArchiveVolumes(dirFilename, dirFilename, cfg.maxArchiveSize, opts)
+			if err != nil {
+				return errors.Wrap(err, "could not write archive")
+			}
+		} else {
+			err = writeArchive(dirFilename, files[0], opts)
+			if err != nil {
+				os.Remove(files[0])
+				return errors.Wrap(err, "could not write archive")
+			}
+		}
+		output = files[len(files)-1]
 
-			fmt.Printf("fetched %d. batch with %d repos\n", i, len(respStr))
-			repos = append(repos, respStr...)
-			if len(respStr) < perPage {
-				return repos, nil
+		err = os.RemoveAll(dirFilename)
+		if err != nil {
+			return errors.Wrap(err, "could not remove working directory")
+		}
+		removed = true
+
+		if cfg.upload.enabled() {
+			slog.Info("uploading archive", "files", len(files))
+			uploadStart := time.Now()
+			locations, err := upload(ctx, cfg.upload, files)
+			if err != nil {
+				return errors.Wrap(err, "could not upload archive")
+			}
+			summary.UploadDuration = time.Since(uploadStart)
+			summary.UploadLocations = locations
+			slog.Info("archive uploaded", "duration", summary.UploadDuration, "locations", strings.Join(locations, ", "))
+			summaryPath, err := storeUploadSummary(summary, dirFilename)
+			if err != nil {
+				return errors.Wrap(err, "could not store upload summary")
 			}
+			slog.Info("upload summary stored", "path", summaryPath)
+		}
+	}
+
+	if interrupted {
+		slog.Error("done, but interrupted before all repositories were archived", "duration", time.Since(start), "output", output,
+			"archived", len(manifest.Repositories), "total", len(reposData))
+		return errInterrupted
+	}
+
+	failed := manifest.failed()
+	if len(failed) > 0 {
+		slog.Error("done, but some repositories failed to clone", "duration", time.Since(start), "output", output,
+			"failed", len(failed), "total", len(reposData), "repos", strings.Join(failed, ", "))
+		return errors.Errorf("%d of %d repositories failed to clone", len(failed), len(reposData))
+	}
+
+	summaryLog.Info("done", "duration", time.Since(start), "output", output)
+	return nil
+}
+
+func printDryRun(reposData []*MinimalRepository) {
+	summaryLog.Info("dry run, the following repositories would be archived")
+	totalKB := 0
+	for _, repo := range reposData {
+		summaryLog.Info("repository", "repo", repo.Name, "size_kb", repo.Size)
+		totalKB += repo.Size
+	}
+	summaryLog.Info("dry run finished", "count", len(reposData), "size_kb", totalKB)
+}
+
+// clearOutputs makes room for a run writing to the fixed name OUTPUT_NAME,
+// refusing to touch the working directory or archives of a previous run
+// unless forced to remove them.
+func clearOutputs(name string, cfg runConfig) error {
+	ext := archiveExtension(cfg.archiveFormat, cfg.recipient)
+	volumes, err := filepath.Glob(name + ".part*" + ext)
+	if err != nil {
+		return errors.Wrap(err, "could not look for previous archive volumes")
+	}
+
+	existing := []string{}
+	for _, path := range append([]string{name, name + ext, name + ".index.json"}, volumes...) {
+		if _, err := os.Lstat(path); err == nil {
+			existing = append(existing, path)
 		}
 	}
+	if len(existing) == 0 {
+		return nil
+	}
+	if !cfg.force {
+		return errors.Errorf("output '%s' already exists, pass --force to overwrite it", strings.Join(existing, "', '"))
+	}
+
+	for _, path := range existing {
+		err := os.RemoveAll(path)
+		if err != nil {
+			return errors.Wrap(err, "could not remove previous output")
+		}
+	}
+	slog.Info("previous output removed", "files", strings.Join(existing, ", "))
+	return nil
+}
+
+// listRepos fetches the repositories of cfg.org page by page, or reads them
+// at once from cfg.fromManifest.
+func listRepos(ctx context.Context, cfg runConfig, onPage func([]*MinimalRepository)) ([]*MinimalRepository, error) {
+	if cfg.fromManifest == "" {
+		return fetchReposData(ctx, cfg.api, cfg.reposPath, cfg.org, cfg.reposQuery, onPage)
+	}
+
+	path := cfg.fromManifest
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "responses.json")
+	}
+	repos := []*MinimalRepository{}
+	err := readJSONFile(path, &repos)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("repositories read from previous run, not fetching them", "file", path, "count", len(repos))
+	onPage(repos)
 	return repos, nil
 }
+
+// storeReposResponses writes the repositories to responses.json, which is
+// replaced at once so that a crash never leaves a partial list behind.
+func storeReposResponses(reposData []*MinimalRepository, dirFilename string) error {
+	slog.Debug("saving fetched repositories responses to file")
+	j, err := json.MarshalIndent(reposData, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal repos")
+	}
+	path := dirFilename + "/responses.json"
+	err = os.WriteFile(path+".tmp", j, fileMode)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not write to file")
+	}
+	slog.Info("fetched repositories responses saved to file")
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	statusCloned = "cloned"
+	statusEmpty  = "empty"
+	statusFailed = "failed"
+	// statusMissingRef marks repositories without the CLONE_REF to clone.
+	statusMissingRef = "missing_ref"
+)
+
+const (
+	submodulesFetched    = "fetched"
+	submodulesNotFetched = "not fetched"
+)
+
+const (
+	// wikiDir holds wiki repositories, in wiki/<repo>/.
+	wikiDir    = "wiki"
+	wikiCloned = "cloned"
+	wikiNone   = "none"
+	wikiFailed = "failed"
+)
+
+// emptyRepoMarker is created in place of the clone of a repository without
+// any commits.
+const emptyRepoMarker = ".empty"
+
+// RepoArchiveResult records the outcome of archiving a single repository.
+type RepoArchiveResult struct {
+	Name           string        `json:"name"`
+	Directory      string        `json:"directory"`
+	Status         string        `json:"status"`
+	Error          string        `json:"error,omitempty"`
+	Duration       time.Duration `json:"duration_ns"`
+	SizeBytes      int64         `json:"size_bytes"`
+	HeadSHA        string        `json:"head_sha,omitempty"`
+	HeadCommitTime time.Time     `json:"head_commit_time"`
+	PushedAt       string        `json:"pushed_at,omitempty"`
+	Reused         bool          `json:"reused,omitempty"`
+	LFS            string        `json:"lfs,omitempty"`
+	Submodules     string        `json:"submodules,omitempty"`
+	Releases       int           `json:"releases,omitempty"`
+	Issues         int           `json:"issues,omitempty"`
+	Pulls          int           `json:"pulls,omitempty"`
+	Wiki           string        `json:"wiki,omitempty"`
+	MetadataErrors []string      `json:"metadata_errors,omitempty"`
+	// ReportedSizeBytes is the size GitHub reports for the repository, to be
+	// compared with ObjectsSizeBytes of the cloned git objects.
+	ReportedSizeBytes int64 `json:"reported_size_bytes,omitempty"`
+	ObjectsSizeBytes  int64 `json:"objects_size_bytes,omitempty"`
+	// HookExitCode is the exit code of POST_CLONE_HOOK, -1 when it could not
+	// be run.
+	HookExitCode      *int `json:"hook_exit_code,omitempty"`
+	Collaborators     int  `json:"collaborators,omitempty"`
+	Webhooks          int  `json:"webhooks,omitempty"`
+	ProtectedBranches int  `json:"protected_branches,omitempty"`
+	// GitStripped is set when only the checked out files were archived,
+	// without the git directory.
+	GitStripped bool `json:"git_stripped,omitempty"`
+	// CloneOptions are missing from manifests of versions which did not
+	// record them.
+	CloneOptions *CloneOptions `json:"clone_options,omitempty"`
+}
+
+// CloneOptions are the options deciding what a clone holds. Incremental runs
+// only reuse clones made with the same options.
+type CloneOptions struct {
+	Depth        int    `json:"depth,omitempty"`
+	Mirror       bool   `json:"mirror,omitempty"`
+	AllBranches  bool   `json:"all_branches,omitempty"`
+	SingleBranch bool   `json:"single_branch,omitempty"`
+	Ref          string `json:"ref,omitempty"`
+	LFS          bool   `json:"lfs,omitempty"`
+	Submodules   bool   `json:"submodules,omitempty"`
+	StripGit     bool   `json:"strip_git,omitempty"`
+}
+
+// Manifest lists what the archive actually contains, as opposed to
+// responses.json which lists what the API reported.
+type Manifest struct {
+	Repositories []RepoArchiveResult `json:"repositories"`
+	// Version is the version of the tool which made the archive.
+	Version string `json:"version,omitempty"`
+}
+
+// collect appends results to the manifest until the channel is closed.
+func (m *Manifest) collect(results <-chan RepoArchiveResult) {
+	for result := range results {
+		m.Repositories = append(m.Repositories, result)
+	}
+	sort.Slice(m.Repositories, func(i, j int) bool {
+		return m.Repositories[i].Name < m.Repositories[j].Name
+	})
+}
+
+func (m *Manifest) failed() []string {
+	failed := []string{}
+	for _, repo := range m.Repositories {
+		if repo.Status == statusFailed {
+			failed = append(failed, repo.Name)
+		}
+	}
+	return failed
+}
+
+// slowest returns up to n repositories which took the longest to clone, the
+// slowest first. Reused clones are left out.
+func (m *Manifest) slowest(n int) []RepoArchiveResult {
+	cloned := []RepoArchiveResult{}
+	for _, repo := range m.Repositories {
+		if !repo.Reused && repo.Duration > 0 {
+			cloned = append(cloned, repo)
+		}
+	}
+	sort.SliceStable(cloned, func(i, j int) bool {
+		return cloned[i].Duration > cloned[j].Duration
+	})
+	return cloned[:min(n, len(cloned))]
+}
+
+// commitTimes maps directories of cloned repositories to their HEAD commit time.
+func (m *Manifest) commitTimes() map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, repo := range m.Repositories {
+		if repo.Status == statusCloned && !repo.HeadCommitTime.IsZero() {
+			times[repo.Directory] = repo.HeadCommitTime
+		}
+	}
+	return times
+}
+
+func storeManifest(m *Manifest, dirFilename string) error {
+	j, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dirFilename+"/manifest.json", j, fileMode)
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
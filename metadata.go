@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// metadataDir holds per repository data fetched from the API which is not
+	// part of the git repository itself, in metadata/<repo>/.
+	metadataDir          = "metadata"
+	releasesPathFmt      = "%s/repos/%s/releases"
+	issuesPathFmt        = "%s/repos/%s/issues?state=all"
+	issueCommentsPathFmt = "%s/repos/%s/issues/%d/comments"
+	pullsPathFmt         = "%s/repos/%s/pulls?state=all"
+	pullReviewsPathFmt   = "%s/repos/%s/pulls/%d/reviews"
+	pullCommentsPathFmt  = "%s/repos/%s/pulls/%d/comments"
+	collaboratorsPathFmt = "%s/repos/%s/collaborators?affiliation=all"
+	teamsPathFmt         = "%s/repos/%s/teams"
+	hooksPathFmt         = "%s/repos/%s/hooks"
+	protectedPathFmt     = "%s/repos/%s/branches?protected=true"
+	protectionPathFmt    = "%s/repos/%s/branches/%s/protection"
+)
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	// Url is the API url of the asset, which serves its content when
+	// requested with the octet-stream media type.
+	Url string `json:"url"`
+}
+
+// issueWithComments is the format of entries in issues.json, both fields are
+// kept exactly as returned by the API.
+type issueWithComments struct {
+	Issue    json.RawMessage   `json:"issue"`
+	Comments []json.RawMessage `json:"comments"`
+}
+
+// pullWithReviews is the format of entries in pulls.json, all fields are
+// kept exactly as returned by the API.
+type pullWithReviews struct {
+	Pull           json.RawMessage   `json:"pull"`
+	Reviews        []json.RawMessage `json:"reviews"`
+	ReviewComments []json.RawMessage `json:"review_comments"`
+}
+
+type branch struct {
+	Name string `json:"name"`
+}
+
+// repoAccess is the format of access.json, items are kept exactly as returned
+// by the API. Collaborators carry their permissions on the repository.
+type repoAccess struct {
+	Collaborators []json.RawMessage `json:"collaborators"`
+	Teams         []json.RawMessage `json:"teams"`
+}
+
+func repoMetadataDir(dirFilename string, repo *MinimalRepository) string {
+	return filepath.Join(dirFilename, metadataDir, repo.Name)
+}
+
+// fetchReleases stores metadata of all releases of repo in releases.json and
+// downloads their assets into releases/<tag>/. It returns the number of
+// releases found.
+func fetchReleases(ctx context.Context, api *apiClient, dir string, repo *MinimalRepository) (int, error) {
+	raw, err := fetchAll(ctx, api, fmt.Sprintf(releasesPathFmt, api.baseURL, repo.FullName))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch releases")
+	}
+
+	err = storeJSON(filepath.Join(dir, "releases.json"), raw)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store releases")
+	}
+
+	for _, r := range raw {
+		rel := release{}
+		err := json.Unmarshal(r, &rel)
+		if err != nil {
+			return 0, errors.Wrap(err, "could not decode release")
+		}
+
+		tagDir := filepath.Join(dir, "releases", strings.ReplaceAll(rel.TagName, "/", "_"))
+		for _, asset := range rel.Assets {
+			err := downloadAsset(ctx, api, asset.Url, filepath.Join(tagDir, filepath.Base(asset.Name)))
+			if err != nil {
+				return 0, errors.Wrapf(err, "could not download asset '%s' of release '%s'", asset.Name, rel.TagName)
+			}
+		}
+	}
+	return len(raw), nil
+}
+
+// fetchIssues stores all issues of repo together with their comments in
+// issues.json. Like the API it lists pull requests as issues too, which
+// keeps their conversation comments. It returns the number of issues found.
+func fetchIssues(ctx context.Context, api *apiClient, dir string, repo *MinimalRepository) (int, error) {
+	issues := []issueWithComments{}
+	err := paginate(ctx, api, fmt.Sprintf(issuesPathFmt, api.baseURL, repo.FullName), func(page []json.RawMessage) {
+		for _, issue := range page {
+			issues = append(issues, issueWithComments{Issue: issue, Comments: []json.RawMessage{}})
+		}
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch issues")
+	}
+
+	for i := range issues {
+		issue := struct {
+			Number   int `json:"number"`
+			Comments int `json:"comments"`
+		}{}
+		err := json.Unmarshal(issues[i].Issue, &issue)
+		if err != nil {
+			return 0, errors.Wrap(err, "could not decode issue")
+		}
+		if issue.Comments == 0 {
+			continue
+		}
+
+		err = paginate(ctx, api, fmt.Sprintf(issueCommentsPathFmt, api.baseURL, repo.FullName, issue.Number), func(page []json.RawMessage) {
+			issues[i].Comments = append(issues[i].Comments, page...)
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not fetch comments of issue %d", issue.Number)
+		}
+	}
+
+	err = storeJSON(filepath.Join(dir, "issues.json"), issues)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store issues")
+	}
+	return len(issues), nil
+}
+
+// fetchPulls stores all pull requests of repo together with their reviews
+// and review comments in pulls.json. It returns the number of pull requests
+// found.
+func fetchPulls(ctx context.Context, api *apiClient, dir string, repo *MinimalRepository) (int, error) {
+	pulls := []pullWithReviews{}
+	err := paginate(ctx, api, fmt.Sprintf(pullsPathFmt, api.baseURL, repo.FullName), func(page []json.RawMessage) {
+		for _, pull := range page {
+			pulls = append(pulls, pullWithReviews{Pull: pull})
+		}
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch pull requests")
+	}
+
+	for i := range pulls {
+		pull := struct {
+			Number int `json:"number"`
+		}{}
+		err := json.Unmarshal(pulls[i].Pull, &pull)
+		if err != nil {
+			return 0, errors.Wrap(err, "could not decode pull request")
+		}
+
+		pulls[i].Reviews, err = fetchAll(ctx, api, fmt.Sprintf(pullReviewsPathFmt, api.baseURL, repo.FullName, pull.Number))
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not fetch reviews of pull request %d", pull.Number)
+		}
+		pulls[i].ReviewComments, err = fetchAll(ctx, api, fmt.Sprintf(pullCommentsPathFmt, api.baseURL, repo.FullName, pull.Number))
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not fetch review comments of pull request %d", pull.Number)
+		}
+	}
+
+	err = storeJSON(filepath.Join(dir, "pulls.json"), pulls)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store pull requests")
+	}
+	return len(pulls), nil
+}
+
+// fetchAccess stores the collaborators of repo, of any affiliation, and the
+// teams with access to it in access.json. Repositories of users have no
+// teams. It returns the number of collaborators found.
+func fetchAccess(ctx context.Context, api *apiClient, dir string, repo *MinimalRepository) (int, error) {
+	access := repoAccess{}
+	var err error
+	access.Collaborators, err = fetchAll(ctx, api, fmt.Sprintf(collaboratorsPathFmt, api.baseURL, repo.FullName))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch collaborators")
+	}
+	access.Teams, err = fetchAll(ctx, api, fmt.Sprintf(teamsPathFmt, api.baseURL, repo.FullName))
+	if hasStatus(err, http.StatusNotFound) {
+		access.Teams = []json.RawMessage{}
+	} else if err != nil {
+		return 0, errors.Wrap(err, "could not fetch teams")
+	}
+
+	err = storeJSON(filepath.Join(dir, "access.json"), access)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store access")
+	}
+	return len(access.Collaborators), nil
+}
+
+// fetchWebhooks stores the webhooks of repo in webhooks.json, without the
+// secret which the API only returns masked anyway. Tokens without access to
+// the webhooks of repo get a 404, which is logged and only skips them. It
+// returns the number of webhooks found.
+func fetchWebhooks(ctx context.Context, api *apiClient, dir string, repo *MinimalRepository) (int, error) {
+	raw, err := fetchAll(ctx, api, fmt.Sprintf(hooksPathFmt, api.baseURL, repo.FullName))
+	if hasStatus(err, http.StatusNotFound, http.StatusForbidden) {
+		slog.Warn("token cannot read webhooks of repository, skipping them", "repo", repo.Name)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch webhooks")
+	}
+
+	hooks := []map[string]any{}
+	for _, r := range raw {
+		hook := map[string]any{}
+		err := json.Unmarshal(r, &hook)
+		if err != nil {
+			return 0, errors.Wrap(err, "could not decode webhook")
+		}
+		if config, ok := hook["config"].(map[string]any); ok {
+			delete(config, "secret")
+		}
+		hooks = append(hooks, hook)
+	}
+
+	err = storeJSON(filepath.Join(dir, "webhooks.json"), hooks)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store webhooks")
+	}
+	return len(hooks), nil
+}
+
+// fetchBranchProtection stores the protection rules of every protected branch
+// of repo in branch_protection.json, keyed by branch name. A 404 means the
+// branch lost its protection meanwhile, a 403 that the token, or the plan of
+// the owner for private repositories, does not allow reading rules, which is
+// logged and only skips them. It returns the number of protected branches.
+func fetchBranchProtection(ctx context.Context, api *apiClient, dir string, repo *MinimalRepository) (int, error) {
+	branches := []branch{}
+	err := paginate(ctx, api, fmt.Sprintf(protectedPathFmt, api.baseURL, repo.FullName), func(page []branch) {
+		branches = append(branches, page...)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch protected branches")
+	}
+
+	rules := map[string]json.RawMessage{}
+	for _, branch := range branches {
+		rule := json.RawMessage{}
+		err := fetchJSON(ctx, api, fmt.Sprintf(protectionPathFmt, api.baseURL, repo.FullName, url.PathEscape(branch.Name)), &rule)
+		if hasStatus(err, http.StatusNotFound) {
+			continue
+		}
+		if hasStatus(err, http.StatusForbidden) {
+			slog.Warn("token cannot read branch protection of repository, skipping it", "repo", repo.Name)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not fetch protection of branch '%s'", branch.Name)
+		}
+		rules[branch.Name] = rule
+	}
+
+	err = storeJSON(filepath.Join(dir, "branch_protection.json"), rules)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store branch protection")
+	}
+	return len(rules), nil
+}
+
+// fetchJSON decodes the single object at apiURL into v.
+func fetchJSON(ctx context.Context, api *apiClient, apiURL string, v any) error {
+	r, err := newAPIRequest(ctx, api, apiURL)
+	if err != nil {
+		return err
+	}
+	resp, err := api.doAPIRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, fmt.Sprintf("for '%s'", r.URL.Path))
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "could not decode response")
+}
+
+// fetchAll collects every page of a listing as raw JSON items.
+func fetchAll(ctx context.Context, api *apiClient, pageURL string) ([]json.RawMessage, error) {
+	items := []json.RawMessage{}
+	err := paginate(ctx, api, pageURL, func(page []json.RawMessage) {
+		items = append(items, page...)
+	})
+	return items, err
+}
+
+func downloadAsset(ctx context.Context, api *apiClient, assetURL, dest string) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "could not create new http request")
+	}
+	token, err := api.tokens.Token(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not get token")
+	}
+	r.Header.Set("Accept", "application/octet-stream")
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := api.doAPIRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "downloading release asset")
+	}
+
+	err = os.MkdirAll(filepath.Dir(dest), dirMode)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, resp.Body)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func storeJSON(path string, v any) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), dirMode)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, j, fileMode)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are served on METRICS_ADDR, they are updated whether served or not.
+var (
+	reposFetchedMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "archive_github_org_repos_fetched_total",
+		Help: "Repositories listed by the API.",
+	})
+	reposArchivedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "archive_github_org_repos_total",
+		Help: "Repositories done cloning, by manifest status.",
+	}, []string{"status"})
+	bytesClonedMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "archive_github_org_cloned_bytes_total",
+		Help: "Bytes written to the working directory by clones.",
+	})
+	cloneDurationMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "archive_github_org_clone_duration_seconds",
+		Help:    "Duration of repository clones.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+)
+
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(reposFetchedMetric, reposArchivedMetric, bytesClonedMetric, cloneDurationMetric)
+	return registry
+}
+
+// recordMetrics counts the result of archiving a single repository.
+func recordMetrics(result RepoArchiveResult) {
+	reposArchivedMetric.WithLabelValues(result.Status).Inc()
+	bytesClonedMetric.Add(float64(result.SizeBytes))
+	if result.Status == statusCloned && !result.Reused {
+		cloneDurationMetric.Observe(result.Duration.Seconds())
+	}
+}
+
+// serveMetrics serves the metrics in the Prometheus format on addr until ctx
+// is done. Failing to listen is logged without stopping the run.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(newMetricsRegistry(), promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("serving metrics", "addr", addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		slog.Error("could not serve metrics", "addr", addr, "err", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// parallelZipMaxFileSize is the largest file compressed in memory by the
+// compression workers, bigger ones are streamed by the writer itself.
+const parallelZipMaxFileSize = 16 << 20
+
+// zipJob is a single entry on its way into the archive. Jobs are written in
+// the order they were queued, once done is closed.
+type zipJob struct {
+	header *zip.FileHeader
+	// raw marks data as already compressed according to header.
+	raw  bool
+	data []byte
+	done chan struct{}
+}
+
+// parallelZipArchiveWriter deflates files on several goroutines while a
+// single one writes the compressed entries to the archive in order, which
+// keeps local headers and the central directory consistent.
+type parallelZipArchiveWriter struct {
+	w     *zip.Writer
+	level int
+	// sem bounds the number of files compressed at once.
+	sem   chan struct{}
+	queue chan *zipJob
+	// pending counts queued jobs not written yet.
+	pending sync.WaitGroup
+	written chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelZipArchiveWriter(w io.Writer, level, workers int) *parallelZipArchiveWriter {
+	p := &parallelZipArchiveWriter{
+		w:       zip.NewWriter(w),
+		level:   level,
+		sem:     make(chan struct{}, workers),
+		queue:   make(chan *zipJob, 2*workers),
+		written: make(chan struct{}),
+	}
+	// used for the files too big to be compressed by the workers
+	p.w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	go p.write()
+	return p
+}
+
+func (p *parallelZipArchiveWriter) write() {
+	defer close(p.written)
+	for job := range p.queue {
+		<-job.done
+		if p.failed() == nil {
+			p.fail(p.writeJob(job))
+		}
+		p.pending.Done()
+	}
+}
+
+func (p *parallelZipArchiveWriter) writeJob(job *zipJob) error {
+	var writer io.Writer
+	var err error
+	if job.raw {
+		writer, err = p.w.CreateRaw(job.header)
+	} else {
+		writer, err = p.w.CreateHeader(job.header)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(job.data)
+	return err
+}
+
+func (p *parallelZipArchiveWriter) writeEntry(entry archiveEntry) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+
+	if entry.linkTarget != "" {
+		header := &zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Store,
+			Modified: entry.modified,
+		}
+		header.SetMode(os.ModeSymlink)
+		p.enqueue(&zipJob{header: header, data: []byte(entry.linkTarget), done: closedChan()})
+		return nil
+	}
+
+	header, err := zip.FileInfoHeader(entry.info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	header.Modified = entry.modified
+	header.Method = zip.Deflate
+
+	if !entry.info.Mode().IsRegular() {
+		header.Method = zip.Store
+		p.enqueue(&zipJob{header: header, done: closedChan()})
+		return nil
+	}
+
+	if entry.info.Size() > parallelZipMaxFileSize {
+		// wait for the queue to drain, then stream the file directly
+		p.pending.Wait()
+		if err := p.failed(); err != nil {
+			return err
+		}
+		writer, err := p.w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, entry.data)
+		return err
+	}
+
+	// the content is read here, as entry.data is only valid during the call
+	data, err := io.ReadAll(entry.data)
+	if err != nil {
+		return err
+	}
+	job := &zipJob{header: header, raw: true, done: make(chan struct{})}
+	p.enqueue(job)
+	p.sem <- struct{}{}
+	go func() {
+		defer close(job.done)
+		defer func() { <-p.sem }()
+		p.fail(p.compress(job, data))
+	}()
+	return nil
+}
+
+// compress deflates data into job, filling in the sizes and checksum
+// CreateRaw expects in the header.
+func (p *parallelZipArchiveWriter) compress(job *zipJob, data []byte) error {
+	buf := &bytes.Buffer{}
+	fw, err := flate.NewWriter(buf, p.level)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	if err != nil {
+		return err
+	}
+	err = fw.Close()
+	if err != nil {
+		return err
+	}
+
+	job.header.CRC32 = crc32.ChecksumIEEE(data)
+	job.header.UncompressedSize64 = uint64(len(data))
+	job.header.CompressedSize64 = uint64(buf.Len())
+	job.data = buf.Bytes()
+	return nil
+}
+
+func (p *parallelZipArchiveWriter) enqueue(job *zipJob) {
+	p.pending.Add(1)
+	p.queue <- job
+}
+
+func (p *parallelZipArchiveWriter) fail(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *parallelZipArchiveWriter) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *parallelZipArchiveWriter) Close() error {
+	close(p.queue)
+	<-p.written
+	if err := p.failed(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// fetchTask fetches one kind of data of a repository, like its releases.
+type fetchTask[T any] struct {
+	name  string
+	fetch func(ctx context.Context) (T, error)
+}
+
+// fetchWorkerPool runs fetch tasks of all repositories being archived, at most
+// a fixed number at a time however many clone workers hand tasks to it.
+type fetchWorkerPool[T any] struct {
+	slots chan struct{}
+}
+
+func newFetchWorkerPool[T any](concurrency int) *fetchWorkerPool[T] {
+	return &fetchWorkerPool[T]{slots: make(chan struct{}, concurrency)}
+}
+
+// run runs the tasks of a repository concurrently once slots of the pool are
+// free, blocking until all of them are done. It returns their results and
+// errors in the order of tasks. Tasks not started before ctx is done fail
+// with its error.
+func (p *fetchWorkerPool[T]) run(ctx context.Context, tasks []fetchTask[T]) ([]T, []error) {
+	results := make([]T, len(tasks))
+	errs := make([]error, len(tasks))
+	wg := &sync.WaitGroup{}
+	for i, task := range tasks {
+		select {
+		case <-ctx.Done():
+			errs[i] = errors.Wrapf(ctx.Err(), "could not fetch %s", task.name)
+			continue
+		case p.slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-p.slots }()
+			results[i], errs[i] = task.fetch(ctx)
+		}()
+	}
+	wg.Wait()
+	return results, errs
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const progressInterval = 10 * time.Second
+
+// cloneProgress counts repositories as they pass through the clone queue, it
+// is updated concurrently by the cloning workers.
+type cloneProgress struct {
+	requested atomic.Int64
+	cloned    atomic.Int64
+	failed    atomic.Int64
+}
+
+func (p *cloneProgress) record(result RepoArchiveResult) {
+	if result.Status == statusFailed {
+		p.failed.Add(1)
+		return
+	}
+	p.cloned.Add(1)
+}
+
+// report logs the progress every interval until ctx is done.
+func (p *cloneProgress) report(ctx context.Context, interval time.Duration) {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			slog.Info("progress",
+				"cloned", p.cloned.Load(),
+				"requested", p.requested.Load(),
+				"failed", p.failed.Load(),
+				"elapsed", time.Since(start).Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// repoLogs copies log records of repositories being archived to
+// logs/<repo>.log in the working directory, next to the output of
+// POST_CLONE_HOOK, so that failures can be looked into separately from the
+// interleaved output of the workers.
+type repoLogs struct {
+	dir   string
+	mu    sync.Mutex
+	files map[string]*repoLog
+}
+
+type repoLog struct {
+	file    *os.File
+	handler slog.Handler
+}
+
+func newRepoLogs(dirFilename string) *repoLogs {
+	return &repoLogs{dir: filepath.Join(dirFilename, logsDir), files: map[string]*repoLog{}}
+}
+
+func (l *repoLogs) path(name string) string {
+	return filepath.Join(l.dir, name+".log")
+}
+
+// open starts copying the records of the repository name, until close.
+func (l *repoLogs) open(name string) error {
+	err := os.MkdirAll(l.dir, dirMode)
+	if err != nil {
+		return errors.Wrap(err, "could not create logs directory")
+	}
+	file, err := os.OpenFile(l.path(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return errors.Wrap(err, "could not create repository log")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[name] = &repoLog{
+		file:    file,
+		handler: slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}
+	return nil
+}
+
+func (l *repoLogs) close(name string) {
+	l.mu.Lock()
+	log, ok := l.files[name]
+	delete(l.files, name)
+	l.mu.Unlock()
+	if ok {
+		log.file.Close()
+	}
+}
+
+func (l *repoLogs) handlerFor(name string) slog.Handler {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if log, ok := l.files[name]; ok {
+		return log.handler
+	}
+	return nil
+}
+
+// repoLogHandler passes records on to inner, copying the ones with a repo
+// attribute of an open repository log there as well, whatever their level.
+type repoLogHandler struct {
+	inner slog.Handler
+	logs  *repoLogs
+}
+
+func (h *repoLogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *repoLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var name string
+	r.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "repo" {
+			name = attr.Value.String()
+			return false
+		}
+		return true
+	})
+	if handler := h.logs.handlerFor(name); handler != nil {
+		// losing a copy is not worth failing the record for
+		_ = handler.Handle(ctx, r)
+	}
+
+	if !h.inner.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *repoLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &repoLogHandler{inner: h.inner.WithAttrs(attrs), logs: h.logs}
+}
+
+func (h *repoLogHandler) WithGroup(name string) slog.Handler {
+	return &repoLogHandler{inner: h.inner.WithGroup(name), logs: h.logs}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "time"
+
+// Helpers over the loosely typed fields of the generated MinimalRepository.
+
+// language returns the primary language detected by GitHub, if any.
+func (r *MinimalRepository) language() (string, bool) {
+	language, ok := r.Language.(string)
+	return language, ok && language != ""
+}
+
+// pushedAt returns the time of the last push, absent for repositories that
+// were never pushed to.
+func (r *MinimalRepository) pushedAt() (time.Time, bool) {
+	v, ok := r.PushedAt.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// visibility returns public, private or internal, derived from the private
+// flag when GitHub Enterprise Server predates the visibility field.
+func (r *MinimalRepository) visibility() string {
+	if r.Visibility != "" {
+		return r.Visibility
+	}
+	if r.Private {
+		return "private"
+	}
+	return "public"
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+// restoreCommand recreates the repositories of an extracted archive, given as
+// the first argument of `restore`, in ORG.
+const restoreCommand = "restore"
+
+var errRepoExists = errors.New("repository already exists")
+
+// restoredRepository is the part of the created repository restore uses.
+type restoredRepository struct {
+	CloneUrl string `json:"clone_url"`
+	SshUrl   string `json:"ssh_url"`
+}
+
+// restore creates every repository archived in the extracted archive dir
+// under cfg.org, from its record in responses.json, and pushes its clone.
+// Repositories are restored one at a time to stay clear of the secondary rate
+// limits on creating content. Existing repositories are never overwritten.
+// Wikis and API metadata are not restored.
+func restore(ctx context.Context, cfg runConfig, dir string) error {
+	repos := []*MinimalRepository{}
+	err := readJSONFile(filepath.Join(dir, "responses.json"), &repos)
+	if err != nil {
+		return err
+	}
+	manifest := &Manifest{}
+	err = readJSONFile(filepath.Join(dir, "manifest.json"), manifest)
+	if err != nil {
+		return err
+	}
+	results := map[string]RepoArchiveResult{}
+	for _, result := range manifest.Repositories {
+		results[result.Name] = result
+	}
+
+	restored, existing, failed := 0, []string{}, []string{}
+	for _, repo := range repos {
+		result, ok := results[repo.Name]
+		if !ok || (result.Status != statusCloned && result.Status != statusEmpty) {
+			slog.Warn("repository not in the archive, skipping", "repo", repo.Name)
+			continue
+		}
+		if result.GitStripped {
+			slog.Error("repository archived without its git directory, it cannot be restored", "repo", repo.Name)
+			failed = append(failed, repo.Name)
+			continue
+		}
+
+		err := restoreRepo(ctx, cfg, dir, repo, result)
+		if errors.Is(err, errRepoExists) {
+			slog.Warn("repository already exists in the target, skipping", "repo", repo.Name, "org", cfg.org)
+			existing = append(existing, repo.Name)
+			continue
+		}
+		if err != nil {
+			slog.Error("could not restore repository", "repo", repo.Name, "err", err.Error())
+			failed = append(failed, repo.Name)
+			continue
+		}
+		slog.Info("repository restored", "repo", repo.Name)
+		restored++
+	}
+
+	if len(existing) > 0 || len(failed) > 0 {
+		summaryLog.Error("restored, but some repositories were not", "org", cfg.org, "restored", restored,
+			"existing", strings.Join(existing, ", "), "failed", strings.Join(failed, ", "))
+		return errors.Errorf("%d repositories already existed and %d failed", len(existing), len(failed))
+	}
+	summaryLog.Info("restored", "org", cfg.org, "restored", restored)
+	return nil
+}
+
+func restoreRepo(ctx context.Context, cfg runConfig, dir string, repo *MinimalRepository, result RepoArchiveResult) error {
+	created, err := createRepo(ctx, cfg, repo)
+	if err != nil {
+		return err
+	}
+	if result.Status == statusEmpty {
+		return nil
+	}
+
+	r, err := git.PlainOpen(filepath.Join(dir, result.Directory))
+	if err != nil {
+		return errors.Wrap(err, "could not open archived clone")
+	}
+	refSpecs, err := restoreRefSpecs(r)
+	if err != nil {
+		return errors.Wrap(err, "could not list branches of archived clone")
+	}
+	remoteURL := created.CloneUrl
+	if cfg.clone.sshAuth != nil {
+		remoteURL = created.SshUrl
+	}
+	err = r.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		RemoteURL:  remoteURL,
+		RefSpecs:   refSpecs,
+		Auth:       cfg.clone.auth(),
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return errors.Wrap(err, "could not push archived clone")
+	}
+
+	if repo.DefaultBranch == "" {
+		return nil
+	}
+	return updateRepo(ctx, cfg, repo.Name, map[string]any{"default_branch": repo.DefaultBranch})
+}
+
+// restoreRefSpecs push every branch and tag of the clone r. Working tree
+// clones keep branches other than the checked out one as remote tracking
+// branches, which are pushed as branches unless a local one has the name.
+func restoreRefSpecs(r *git.Repository) ([]config.RefSpec, error) {
+	specs := []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	remote := map[string]bool{}
+	local := map[string]bool{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		switch {
+		case ref.Type() != plumbing.HashReference:
+		case name.IsBranch():
+			local[name.Short()] = true
+		case name.String() == "refs/remotes/origin/HEAD":
+			// single branch clones keep the remote HEAD as a plain ref
+		case name.IsRemote() && strings.HasPrefix(name.String(), "refs/remotes/origin/"):
+			remote[strings.TrimPrefix(name.String(), "refs/remotes/origin/")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for branch := range remote {
+		if !local[branch] {
+			specs = append(specs, config.RefSpec(fmt.Sprintf("+refs/remotes/origin/%s:refs/heads/%s", branch, branch)))
+		}
+	}
+	return specs, nil
+}
+
+// createRepo creates an empty repository with the settings of repo, failing
+// with errRepoExists when the name is taken.
+func createRepo(ctx context.Context, cfg runConfig, repo *MinimalRepository) (restoredRepository, error) {
+	createURL := cfg.api.baseURL + fmt.Sprintf("/orgs/%s/repos", cfg.org)
+	if cfg.reposPath == userReposPath {
+		// users can only create repositories for themselves
+		createURL = cfg.api.baseURL + "/user/repos"
+	}
+	payload := map[string]any{
+		"name":         repo.Name,
+		"private":      repo.Private,
+		"has_issues":   repo.HasIssues,
+		"has_projects": repo.HasProjects,
+		"has_wiki":     repo.HasWiki,
+		"is_template":  repo.IsTemplate,
+		"auto_init":    false,
+	}
+	if description, ok := repo.Description.(string); ok {
+		payload["description"] = description
+	}
+	if homepage, ok := repo.Homepage.(string); ok {
+		payload["homepage"] = homepage
+	}
+	if repo.Visibility != "" && cfg.reposPath != userReposPath {
+		payload["visibility"] = repo.Visibility
+	}
+
+	r, err := newAPIJSONRequest(ctx, cfg.api, http.MethodPost, createURL, payload)
+	if err != nil {
+		return restoredRepository{}, err
+	}
+	resp, err := cfg.api.doAPIRequest(ctx, r)
+	if err != nil {
+		return restoredRepository{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if strings.Contains(string(body), "already exists") {
+			return restoredRepository{}, errRepoExists
+		}
+		return restoredRepository{}, errors.Errorf("github refused to create the repository: %s", body)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return restoredRepository{}, responseError(resp, "creating repository")
+	}
+
+	created := restoredRepository{}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	if err != nil {
+		return restoredRepository{}, errors.Wrap(err, "could not decode created repository")
+	}
+	return created, nil
+}
+
+// updateRepo changes settings of a restored repository.
+func updateRepo(ctx context.Context, cfg runConfig, name string, settings map[string]any) error {
+	r, err := newAPIJSONRequest(ctx, cfg.api, http.MethodPatch, cfg.api.baseURL+fmt.Sprintf("/repos/%s/%s", cfg.org, name), settings)
+	if err != nil {
+		return err
+	}
+	resp, err := cfg.api.doAPIRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "updating repository")
+	}
+	return nil
+}
+
+func readJSONFile(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not read '%s'", path)
+	}
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return errors.Wrapf(err, "could not decode '%s'", path)
+	}
+	return nil
+}
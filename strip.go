@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// stripClone removes the git directories of the clone of repo once nothing
+// needs them anymore, leaving a snapshot of its checked out files. The size in
+// result is updated to what is left, a clone which could not be stripped
+// fails rather than being archived with part of its history.
+func stripClone(dirFilename string, repo *MinimalRepository, result *RepoArchiveResult) {
+	repoDir := filepath.Join(dirFilename, result.Directory)
+	err := removeGitDirs(repoDir)
+	if err != nil {
+		slog.Error("could not remove git directory", "repo", repo.Name, "err", err.Error())
+		result.Status = statusFailed
+		result.Error = err.Error()
+		return
+	}
+	result.GitStripped = true
+
+	result.SizeBytes, err = dirSize(repoDir)
+	if err != nil {
+		slog.Warn("could not calculate repository size", "repo", repo.Name, "err", err)
+	}
+	slog.Debug("git directory removed", "repo", repo.Name, "size_bytes", result.SizeBytes)
+}
+
+// removeGitDirs removes every .git entry below dir: the git directory of the
+// clone and the .git files linking submodules to it.
+func removeGitDirs(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() != ".git" {
+			return nil
+		}
+		err = os.RemoveAll(path)
+		if err != nil {
+			return errors.Wrapf(err, "could not remove '%s'", path)
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// RunSummary captures counts and timings of a single archive run.
+type RunSummary struct {
+	ReposFetched  int           `json:"repos_fetched"`
+	ReposCloned   int           `json:"repos_cloned"`
+	ReposSkipped  int           `json:"repos_skipped"`
+	ReposFailed   int           `json:"repos_failed"`
+	TotalBytes    int64         `json:"total_bytes"`
+	FetchDuration time.Duration `json:"fetch_duration_ns"`
+	CloneDuration time.Duration `json:"clone_duration_ns"`
+	// DuplicateObjects is only reported with REPORT_DUPLICATES.
+	DuplicateObjects *DuplicateObjects `json:"duplicate_objects,omitempty"`
+	// Version is the version of the tool which made the archive.
+	Version string `json:"version"`
+	// UploadDuration and UploadLocations are only known once the archive is
+	// uploaded, so they are missing from the summary.json inside it and only
+	// reported in the one written next to it.
+	UploadDuration  time.Duration `json:"upload_duration_ns,omitempty"`
+	UploadLocations []string      `json:"upload_locations,omitempty"`
+}
+
+// newRunSummary counts filtered out, empty and repositories lacking the
+// requested ref as skipped.
+func newRunSummary(fetched, filtered int, m *Manifest, fetchDuration, cloneDuration time.Duration) RunSummary {
+	summary := RunSummary{
+		ReposFetched:  fetched,
+		ReposSkipped:  filtered,
+		FetchDuration: fetchDuration,
+		CloneDuration: cloneDuration,
+		Version:       toolVersion(),
+	}
+	for _, repo := range m.Repositories {
+		switch repo.Status {
+		case statusCloned:
+			summary.ReposCloned++
+		case statusEmpty, statusMissingRef:
+			summary.ReposSkipped++
+		case statusFailed:
+			summary.ReposFailed++
+		}
+		summary.TotalBytes += repo.SizeBytes
+	}
+	return summary
+}
+
+func storeRunSummary(summary RunSummary, dirFilename string) error {
+	return writeRunSummary(summary, dirFilename+"/summary.json")
+}
+
+// storeUploadSummary writes summary, completed with the upload, next to the
+// archive as <name>.summary.json.
+func storeUploadSummary(summary RunSummary, dirFilename string) (string, error) {
+	path := dirFilename + ".summary.json"
+	return path, writeRunSummary(summary, path)
+}
+
+func writeRunSummary(summary RunSummary, path string) error {
+	j, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, j, fileMode)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+)
+
+// newHTTPClient returns the client of every request to GitHub, API calls and
+// git alike, see useForGit. Requests go through the proxy set with
+// HTTPS_PROXY or HTTP_PROXY unless the host is listed in NO_PROXY, and
+// servers are verified with tlsConfig when it is set.
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}
+}
+
+// loadCACerts returns the system certificate pool extended with the PEM
+// certificates of the file at path.
+func loadCACerts(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read certificates")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no PEM certificates found in '%s'", path)
+	}
+	return pool, nil
+}
+
+// useForGit makes go-git clone, fetch and push over HTTP and HTTPS with c
+// instead of its own default client.
+func useForGit(c *http.Client) {
+	gitclient.InstallProtocol("https", githttp.NewClient(c))
+	gitclient.InstallProtocol("http", githttp.NewClient(c))
+}
+
+// proxyFor is the proxy requests to rawURL go through, nil for none.
+func proxyFor(rawURL string) (*url.URL, error) {
+	r, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyFromEnvironment(r)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// uploader stores a finished archive file outside the working directory and
+// returns where it ended up.
+type uploader interface {
+	Upload(ctx context.Context, file string) (string, error)
+	Close() error
+}
+
+// uploadConfig selects the destinations archives are uploaded to, all of
+// them when several are set.
+type uploadConfig struct {
+	s3 s3Config
+	// gcsBucket is a Cloud Storage bucket written to with application
+	// default credentials.
+	gcsBucket string
+	// localDir is a directory archives are copied to, e.g. a mounted
+	// network share.
+	localDir    string
+	deleteLocal bool
+}
+
+// s3Config configures uploading to an S3 compatible bucket, with credentials
+// taken from the standard AWS environment and config files.
+type s3Config struct {
+	bucket string
+	// endpoint, when set, points to S3 compatible storage other than AWS,
+	// which is addressed with path style URLs.
+	endpoint string
+}
+
+func (cfg uploadConfig) enabled() bool {
+	return cfg.s3.bucket != "" || cfg.gcsBucket != "" || cfg.localDir != ""
+}
+
+// newUploaders creates an uploader for every destination set in cfg.
+func newUploaders(ctx context.Context, cfg uploadConfig) ([]uploader, error) {
+	uploaders := []uploader{}
+	if cfg.s3.bucket != "" {
+		u, err := newS3Uploader(ctx, cfg.s3)
+		if err != nil {
+			return nil, err
+		}
+		uploaders = append(uploaders, u)
+	}
+	if cfg.gcsBucket != "" {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create Cloud Storage client")
+		}
+		uploaders = append(uploaders, &gcsUploader{client: client, bucket: cfg.gcsBucket})
+	}
+	if cfg.localDir != "" {
+		uploaders = append(uploaders, localUploader(cfg.localDir))
+	}
+	return uploaders, nil
+}
+
+// s3Uploader uploads files under their base names, in multiple parts for
+// large files.
+type s3Uploader struct {
+	uploader *manager.Uploader
+	bucket   string
+}
+
+func newS3Uploader(ctx context.Context, cfg s3Config) (*s3Uploader, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load AWS configuration")
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Uploader{uploader: manager.NewUploader(client), bucket: cfg.bucket}, nil
+}
+
+func (u *s3Uploader) Upload(ctx context.Context, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrap(err, "could not open file to upload")
+	}
+	defer f.Close()
+
+	key := filepath.Base(file)
+	out, err := u.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "could not upload '%s' to S3 bucket '%s'", key, u.bucket)
+	}
+	return out.Location, nil
+}
+
+func (u *s3Uploader) Close() error {
+	return nil
+}
+
+// gcsUploader writes files to Cloud Storage objects named after them.
+type gcsUploader struct {
+	client *storage.Client
+	bucket string
+}
+
+func (u *gcsUploader) Upload(ctx context.Context, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrap(err, "could not open file to upload")
+	}
+	defer f.Close()
+
+	key := filepath.Base(file)
+	w := u.client.Bucket(u.bucket).Object(key).NewWriter(ctx)
+	_, err = io.Copy(w, f)
+	if err != nil {
+		w.Close()
+		return "", errors.Wrapf(err, "could not upload '%s' to Cloud Storage bucket '%s'", key, u.bucket)
+	}
+	err = w.Close()
+	if err != nil {
+		return "", errors.Wrapf(err, "could not upload '%s' to Cloud Storage bucket '%s'", key, u.bucket)
+	}
+	return fmt.Sprintf("gs://%s/%s", u.bucket, key), nil
+}
+
+func (u *gcsUploader) Close() error {
+	return u.client.Close()
+}
+
+// localUploader copies files into a directory.
+type localUploader string
+
+func (dir localUploader) Upload(_ context.Context, file string) (string, error) {
+	dest := filepath.Join(string(dir), filepath.Base(file))
+	err := copyRegularFile(file, dest, fileMode)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not copy '%s' to '%s'", file, dir)
+	}
+	return dest, nil
+}
+
+func (dir localUploader) Close() error {
+	return nil
+}
+
+// upload sends every file to all destinations of cfg, deleting the local
+// files afterwards when asked to. It returns the locations of the uploads.
+func upload(ctx context.Context, cfg uploadConfig, files []string) ([]string, error) {
+	uploaders, err := newUploaders(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, u := range uploaders {
+			u.Close()
+		}
+	}()
+
+	locations := []string{}
+	for _, u := range uploaders {
+		for _, file := range files {
+			start := time.Now()
+			location, err := u.Upload(ctx, file)
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("file uploaded", "file", filepath.Base(file), "location", location, "duration", time.Since(start))
+			locations = append(locations, location)
+		}
+	}
+
+	if cfg.deleteLocal {
+		for _, file := range files {
+			err := os.Remove(file)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not delete uploaded file")
+			}
+		}
+		slog.Info("uploaded files deleted locally", "count", len(files))
+	}
+	return locations, nil
+}
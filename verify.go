@@ -0,0 +1,247 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// verifyCommand checks an archive given as the first argument of `verify`.
+const verifyCommand = "verify"
+
+// verifyReport lists the discrepancies found by verify.
+type verifyReport struct {
+	// Missing repositories exist in the org but are not in the archive.
+	Missing []string
+	// Truncated repositories have files missing or not matching SHA256SUMS.
+	Truncated []string
+	// Deleted repositories are in the archive but no longer in the org.
+	Deleted []string
+}
+
+func (r verifyReport) ok() bool {
+	return len(r.Missing) == 0 && len(r.Truncated) == 0 && len(r.Deleted) == 0
+}
+
+// verify checks the archive at path, a zip or tar.gz file or an extracted
+// directory, against its manifest and checksums and against the repositories
+// currently owned by cfg.org, selected with the same filters. Encrypted
+// archives and volumes have to be decrypted and extracted first.
+func verify(ctx context.Context, cfg runConfig, path string) error {
+	contents, err := readArchiveContents(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not read archive '%s'", path)
+	}
+	if contents.manifest == nil {
+		return errors.Errorf("archive '%s' has no %s", path, "manifest.json")
+	}
+	slog.Info("archive read", "path", path, "files", len(contents.sums), "repos", len(contents.manifest.Repositories))
+
+	fetched, err := fetchReposData(ctx, cfg.api, cfg.reposPath, cfg.org, cfg.reposQuery, func([]*MinimalRepository) {})
+	if err != nil {
+		return errors.Wrap(err, "could not fetch repos data")
+	}
+	current := newRepoSelector(cfg.filters, cfg.maxRepos).selectRepos(fetched)
+
+	report := contents.verify(current, fetched)
+	for _, name := range report.Missing {
+		summaryLog.Warn("repository missing from archive", "repo", name)
+	}
+	for _, name := range report.Truncated {
+		summaryLog.Warn("repository truncated in archive", "repo", name)
+	}
+	for _, name := range report.Deleted {
+		summaryLog.Warn("archived repository no longer exists", "repo", name)
+	}
+	if !report.ok() {
+		return errors.Errorf("archive differs: %d missing, %d truncated, %d deleted repositories",
+			len(report.Missing), len(report.Truncated), len(report.Deleted))
+	}
+	summaryLog.Info("archive verified", "repos", len(contents.manifest.Repositories))
+	return nil
+}
+
+// archiveContents is what verify needs to know about an archive.
+type archiveContents struct {
+	manifest *Manifest
+	// sums maps file names to their SHA-256 as listed in SHA256SUMS, hashes
+	// the SHA-256 computed from the archived files.
+	sums   map[string]string
+	hashes map[string]string
+}
+
+// verify compares the archive with the selected repositories of the org, all
+// of them when looking for deleted ones, as filters may have changed.
+func (c archiveContents) verify(selected, all []*MinimalRepository) verifyReport {
+	report := verifyReport{}
+
+	// a repository is truncated when any file under its directories does not
+	// match its checksum
+	broken := map[string]bool{}
+	for name, sum := range c.sums {
+		if c.hashes[name] != sum {
+			broken[contentRoot(name)] = true
+		}
+	}
+
+	// failed repositories are listed in the manifest without their contents,
+	// so they count as missing
+	archived := map[string]bool{}
+	for _, repo := range c.manifest.Repositories {
+		if repo.Status == statusFailed {
+			continue
+		}
+		archived[repo.Name] = true
+		if repo.Status != statusCloned {
+			continue
+		}
+		if broken[repo.Directory] || broken[metadataDir+"/"+repo.Name] || broken[wikiDir+"/"+repo.Name] {
+			report.Truncated = append(report.Truncated, repo.Name)
+		}
+	}
+
+	for _, repo := range selected {
+		if !archived[repo.Name] {
+			report.Missing = append(report.Missing, repo.Name)
+		}
+	}
+	exists := map[string]bool{}
+	for _, repo := range all {
+		exists[repo.Name] = true
+	}
+	for _, repo := range c.manifest.Repositories {
+		if !exists[repo.Name] {
+			report.Deleted = append(report.Deleted, repo.Name)
+		}
+	}
+	return report
+}
+
+// readArchiveContents hashes every regular file of the archive at path and
+// reads its manifest and checksums.
+func readArchiveContents(path string) (archiveContents, error) {
+	contents := archiveContents{sums: map[string]string{}, hashes: map[string]string{}}
+	err := walkArchive(path, func(name string, r io.Reader) error {
+		switch name {
+		case "manifest.json":
+			contents.manifest = &Manifest{}
+			return json.NewDecoder(r).Decode(contents.manifest)
+		case checksumsFile:
+			return readChecksums(r, contents.sums)
+		}
+		h := sha256.New()
+		_, err := io.Copy(h, r)
+		if err != nil {
+			return errors.Wrapf(err, "could not read '%s'", name)
+		}
+		contents.hashes[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return contents, err
+}
+
+// readChecksums parses lines of sha256sum output into sums.
+func readChecksums(r io.Reader, sums map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return errors.Errorf("invalid %s line '%s'", checksumsFile, scanner.Text())
+		}
+		sums[name] = sum
+	}
+	return scanner.Err()
+}
+
+// walkArchive calls fn with the slash separated name and content of every
+// regular file in the zip or tar.gz archive or directory at path.
+func walkArchive(path string, fn func(name string, r io.Reader) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		return filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return fn(filepath.ToSlash(rel), f)
+		})
+	case strings.HasSuffix(path, encryptedExtension):
+		return errors.New("encrypted archives have to be decrypted first, e.g. with age -d")
+	case strings.HasSuffix(path, "."+formatZip):
+		z, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		for _, file := range z.File {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+			r, err := file.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(file.Name, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.HasSuffix(path, "."+formatTarGz):
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			err = fn(header.Name, tr)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Errorf("expected a .%s or .%s archive or a directory", formatZip, formatTarGz)
+	}
+}
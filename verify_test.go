@@ -0,0 +1,27 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestArchiveContentsVerifyFailedRepo(t *testing.T) {
+	contents := archiveContents{
+		manifest: &Manifest{Repositories: []RepoArchiveResult{
+			{Name: "one", Directory: "one", Status: statusCloned},
+			{Name: "empty", Directory: "empty", Status: statusEmpty},
+			{Name: "broken", Directory: "broken", Status: statusFailed},
+		}},
+		sums:   map[string]string{"one/README": "sum"},
+		hashes: map[string]string{"one/README": "sum"},
+	}
+	org := []*MinimalRepository{testRepo(1, "one"), testRepo(2, "empty"), testRepo(3, "broken")}
+
+	report := contents.verify(org, org)
+	if want := []string{"broken"}; !slices.Equal(report.Missing, want) {
+		t.Errorf("missing %v, want %v", report.Missing, want)
+	}
+	if len(report.Truncated) > 0 || len(report.Deleted) > 0 {
+		t.Errorf("truncated %v and deleted %v, want none", report.Truncated, report.Deleted)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "runtime/debug"
+
+// version is set when building, with -ldflags "-X main.version=v1.2.3".
+// Otherwise it is taken from the build info, see toolVersion.
+var version = ""
+
+// toolVersion reports the version of the running build: the one set at build
+// time, the module version of `go install`, or the VCS revision of a build
+// from a checkout.
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "devel"
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return "devel-" + revision
+}
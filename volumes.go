@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// archiveVolume is a single self-contained part of an archive split with
+// MAX_ARCHIVE_SIZE, listed in the volumes index.
+type archiveVolume struct {
+	File string `json:"file"`
+	// Contents are the repository directories, metadata and wiki
+	// directories and top level files with entries in the volume. Large
+	// repositories can span consecutive volumes.
+	Contents []string `json:"contents"`
+}
+
+// volumeArchiveWriter writes entries to <prefix>.part<N>.<format> archives,
+// starting the next volume at a file boundary once maxSize bytes were written
+// to the current one. Compressed data is buffered, so volumes can overshoot
+// maxSize by the size of a file and the compressor window, or by the files
+// queued to compression workers.
+type volumeArchiveWriter struct {
+	prefix  string
+	opts    archiveOptions
+	maxSize int64
+
+	file    *os.File
+	written *countingWriter
+	enc     io.WriteCloser
+	w       archiveWriter
+	volumes []archiveVolume
+}
+
+func (v *volumeArchiveWriter) writeEntry(entry archiveEntry) error {
+	if v.w == nil || v.written.n.Load() >= v.maxSize {
+		err := v.nextVolume()
+		if err != nil {
+			return err
+		}
+	}
+
+	volume := &v.volumes[len(v.volumes)-1]
+	if content := contentRoot(entry.name); !slices.Contains(volume.Contents, content) {
+		volume.Contents = append(volume.Contents, content)
+	}
+	return v.w.writeEntry(entry)
+}
+
+func (v *volumeArchiveWriter) nextVolume() error {
+	err := v.closeVolume()
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s.part%d%s", v.prefix, len(v.volumes)+1, archiveExtension(v.opts.format, v.opts.recipient))
+	v.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return errors.Wrap(err, "could not open archive volume")
+	}
+	v.written = &countingWriter{w: v.file}
+	v.enc, err = encryptTo(v.written, v.opts.recipient)
+	if err != nil {
+		return errors.Wrap(err, "could not start encryption")
+	}
+	v.w, err = newArchiveWriter(v.enc, v.opts)
+	if err != nil {
+		return errors.Wrap(err, "could not create archive writer")
+	}
+	v.volumes = append(v.volumes, archiveVolume{File: filepath.Base(path), Contents: []string{}})
+	return nil
+}
+
+func (v *volumeArchiveWriter) closeVolume() error {
+	if v.w == nil {
+		return nil
+	}
+
+	err := v.w.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not finalize archive volume")
+	}
+	v.w = nil
+	err = v.enc.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not finalize encryption")
+	}
+	return v.file.Close()
+}
+
+func (v *volumeArchiveWriter) Close() error {
+	return v.closeVolume()
+}
+
+// contentRoot is the directory of the repository an entry belongs to, e.g.
+// repo for repo/README.md and metadata/repo for metadata/repo/issues.json, or
+// the name itself for top level files.
+func contentRoot(name string) string {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) > 2 && (parts[0] == metadataDir || parts[0] == wikiDir) {
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
+
+// writeArchiveVolumes packs dirFilename into archive volumes of roughly
+// maxSize bytes and writes <prefix>.index.json listing what landed in which
+// volume. It returns the paths of the volumes followed by the index.
+func writeArchiveVolumes(dirFilename, prefix string, maxSize int64, opts archiveOptions) ([]string, error) {
+	v := &volumeArchiveWriter{
+		prefix:  prefix,
+		opts:    opts,
+		maxSize: maxSize,
+	}
+	err := fillArchive(dirFilename, v, opts)
+	if err == nil {
+		err = v.Close()
+	}
+	if err != nil {
+		v.closeVolume()
+		for _, volume := range v.volumes {
+			os.Remove(filepath.Join(filepath.Dir(prefix), volume.File))
+		}
+		return nil, errors.Wrap(err, "could not fill archive volumes")
+	}
+
+	indexPath := prefix + ".index.json"
+	j, err := json.MarshalIndent(struct {
+		Volumes []archiveVolume `json:"volumes"`
+	}{v.volumes}, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal volumes index")
+	}
+	err = os.WriteFile(indexPath, j, fileMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not write volumes index")
+	}
+
+	files := []string{}
+	for _, volume := range v.volumes {
+		files = append(files, filepath.Join(filepath.Dir(prefix), volume.File))
+	}
+	return append(files, indexPath), nil
+}
+
+// countingWriter counts the bytes written through it. The count can be read
+// while another goroutine writes, as the parallel zip writer does.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
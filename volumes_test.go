@@ -0,0 +1,61 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWriteArchiveVolumesParallel splits an archive compressed by several
+// workers, run it with -race.
+func TestWriteArchiveVolumesParallel(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "org-archive")
+	rnd := rand.New(rand.NewSource(1))
+	want := map[string]bool{}
+	for i := range 40 {
+		name := fmt.Sprintf("repo%d/file%d", i%4, i)
+		// random data does not compress, so volumes fill up quickly
+		data := make([]byte, 8<<10)
+		rnd.Read(data)
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), dirMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), data, fileMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[name] = true
+	}
+
+	opts := archiveOptions{format: formatZip, level: flate.BestSpeed, workers: 4}
+	files, err := writeArchiveVolumes(dir, dir, 64<<10, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumes := files[:len(files)-1]
+	if len(volumes) < 2 {
+		t.Fatalf("%d volumes, want several", len(volumes))
+	}
+
+	found := map[string]int{}
+	for _, volume := range volumes {
+		r, err := zip.OpenReader(volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.File {
+			found[f.Name]++
+		}
+		r.Close()
+	}
+	for name := range want {
+		if found[name] != 1 {
+			t.Errorf("%s found in %d volumes, want 1", name, found[name])
+		}
+	}
+}